@@ -1,36 +1,158 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/config"
 	"github.com/CTSDM/motbwa-tui/internal/ui"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	defaultPath, err := config.DefaultPath()
+	if err != nil {
 		log.Fatal(err)
-		return
 	}
 
-	PORT_NUMBER := os.Getenv("PORT")
+	configPath := flag.String("config", defaultPath, "path of the JSON config file")
+	profile := flag.String("profile", "", "name of the profile to start with, instead of picking one")
+	serverURL := flag.String("server", "", "base URL of the api server of the default profile")
+	websocketURL := flag.String("ws", "", "URL of the websocket endpoint (default: /ws on the server)")
+	caFile := flag.String("ca-file", "", "PEM bundle of additional trusted certificate authorities")
+	pins := flag.String("pin", "", "comma separated sha256/<base64> pins of the server public key")
+	logFile := flag.String("log-file", "", "file the logs are written to")
+	theme := flag.String("theme", "", "color theme: default, light or mono")
+	timeFormat := flag.String("time-format", "", "layout of the message times, as in Go's time.Format")
+	timeZone := flag.String("time-zone", "", "time zone of the message times, e.g. Europe/Madrid")
+	awayAfter := flag.String("away-after", "", "idle time after which you show as away, e.g. 10m, 0 disables it")
+	pingInterval := flag.String("ping-interval", "", "time between the pings that keep the connection alive, e.g. 10s")
+	pingMaxMissed := flag.Int("ping-max-missed", 0, "unanswered pings in a row after which the connection is dropped")
+	flag.Parse()
 
-	// create state to hold the api information
-	state := api.State{
-		Server: api.ServerInfo{
-			BaseURL:      fmt.Sprintf("http://localhost:%s/", PORT_NUMBER),
-			WebsocketURL: fmt.Sprintf("ws://localhost:%s/ws", PORT_NUMBER),
-			Login:        "api/login",
-			Users:        "api/users",
-		},
+	// a .env file is optional, its values act as environment variables
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal(err)
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	cfg, err := config.Load(*configPath, set["config"])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		log.Fatal(err)
+	}
+
+	// the flags override both the config file and the environment
+	overrides := map[*string]string{
+		&cfg.ServerURL:          *serverURL,
+		&cfg.WebsocketURL:       *websocketURL,
+		&cfg.TLS.CAFile:         *caFile,
+		&cfg.LogFile:            *logFile,
+		&cfg.Theme:              *theme,
+		&cfg.TimeFormat:         *timeFormat,
+		&cfg.TimeZone:           *timeZone,
+		&cfg.AwayAfter:          *awayAfter,
+		&cfg.Heartbeat.Interval: *pingInterval,
+	}
+	for setting, value := range overrides {
+		if value != "" {
+			*setting = value
+		}
+	}
+	if *pins != "" {
+		cfg.TLS.Pins = config.SplitList(*pins)
+	}
+	if set["ping-max-missed"] {
+		cfg.Heartbeat.MaxMissed = *pingMaxMissed
+	}
+	if err := cfg.Resolve(); err != nil {
+		log.Fatal(err)
+	}
+	if *profile == "" {
+		*profile = os.Getenv("MOTBWA_PROFILE")
+	}
+
+	profiles := []ui.Profile{}
+	found := *profile == ""
+	for _, p := range cfg.AllProfiles() {
+		tlsConfig, err := api.NewTLSConfig(p.TLS.CAFile, p.TLS.Pins)
+		if err != nil {
+			log.Fatalf("profile %q: %v", p.Name, err)
+		}
+		profiles = append(profiles, ui.Profile{
+			Name: p.Name,
+			Server: api.ServerInfo{
+				BaseURL:      p.ServerURL,
+				WebsocketURL: p.WebsocketURL,
+				Login:        cfg.Endpoints.Login,
+				Users:        cfg.Endpoints.Users,
+				Keys:         cfg.Endpoints.Keys,
+				Refresh:      cfg.Endpoints.Refresh,
+				Contacts:     cfg.Endpoints.Contacts,
+				Rooms:        cfg.Endpoints.Rooms,
+				Presence:     cfg.Endpoints.Presence,
+			},
+			TLS:      tlsConfig,
+			Username: p.Username,
+		})
+		found = found || p.Name == *profile
+	}
+	if !found {
+		log.Fatalf("there is no profile named %q", *profile)
+	}
+
+	settings := ui.DefaultSettings
+	settings.TimeFormat = cfg.TimeFormat
+	if cfg.TimeZone != "" {
+		location, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		settings.Location = location
+	}
+	settings.Theme, err = ui.LookupTheme(cfg.Theme)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings.AwayAfter, err = time.ParseDuration(cfg.AwayAfter)
+	if err != nil || settings.AwayAfter < 0 {
+		log.Fatalf("invalid away_after %q: it must be a duration like 10m", cfg.AwayAfter)
+	}
+	settings.Heartbeat.Interval, err = time.ParseDuration(cfg.Heartbeat.Interval)
+	if err != nil || settings.Heartbeat.Interval <= 0 {
+		log.Fatalf("invalid heartbeat interval %q: it must be a duration like 10s", cfg.Heartbeat.Interval)
+	}
+	settings.Heartbeat.MaxMissed = cfg.Heartbeat.MaxMissed
+	if settings.Heartbeat.MaxMissed < 1 {
+		log.Fatalf("invalid heartbeat max_missed %d: it must be at least 1", cfg.Heartbeat.MaxMissed)
+	}
+
+	// the terminal belongs to the interface, the logs go to a file
+	if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o700); err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer f.Close()
+	log.SetOutput(f)
 
 	// start bubbletea
-	p := tea.NewProgram(ui.InitialModel(state))
+	p := tea.NewProgram(ui.InitialModel(profiles, *profile, settings))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
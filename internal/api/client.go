@@ -0,0 +1,146 @@
+package api
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a request, including its retries.
+	DefaultTimeout = 30 * time.Second
+	// responseTimeout bounds the wait for the headers of each attempt.
+	responseTimeout = 10 * time.Second
+	// maxDrain is how much of a discarded body is read to reuse the
+	// connection.
+	maxDrain = 4 << 10
+)
+
+// RetryPolicy sets how the idempotent requests are retried after a network
+// error, a 5xx or a 429 response.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, 1 disables the retries.
+	Attempts int
+	// BaseDelay is the first wait, doubled on each retry up to MaxDelay. A
+	// Retry-After longer than MaxDelay is not waited for.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// httpClient returns the client shared by the api requests, built on first
+// use from the settings of s.
+func (s *State) httpClient() *http.Client {
+	s.clientOnce.Do(func() {
+		transport := s.Transport
+		if transport == nil {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.TLSClientConfig = s.TLS
+			t.ResponseHeaderTimeout = responseTimeout
+			transport = t
+		}
+		policy := DefaultRetryPolicy
+		if s.Retry != nil {
+			policy = *s.Retry
+		}
+		timeout := s.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		s.client = &http.Client{
+			Transport: &retryTransport{next: transport, policy: policy},
+			Timeout:   timeout,
+		}
+	})
+	return s.client
+}
+
+// retryTransport retries the idempotent requests that failed for a reason
+// that may go away on its own.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	delay := t.policy.BaseDelay
+	attempt := req
+	for try := 1; ; try++ {
+		res, err := t.next.RoundTrip(attempt)
+		if try >= t.policy.Attempts || !shouldRetry(res, err) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		wait := delay + rand.N(delay/2+1)
+		if res != nil {
+			if after := retryAfter(res.Header); after > t.policy.MaxDelay {
+				return res, nil
+			} else if after > 0 {
+				wait = after
+			}
+			discard(res)
+		}
+		delay = min(2*delay, t.policy.MaxDelay)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		attempt = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+	}
+}
+
+// retryable reports whether req can be sent again without side effects.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func shouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// retryAfter returns the wait asked by the Retry-After header, in seconds
+// or as a date, zero when there is none.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// discard drains and closes the body of a response that is not used, so
+// the connection goes back to the pool.
+func discard(res *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxDrain))
+	res.Body.Close()
+}
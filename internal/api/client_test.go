@@ -0,0 +1,208 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the requests with the responses in order, the last
+// one is repeated, and records what it received.
+type fakeServer struct {
+	mu        sync.Mutex
+	responses []func() (*http.Response, error)
+	times     []time.Time
+	bodies    []string
+}
+
+func (f *fakeServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body := ""
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(data)
+	}
+	f.times = append(f.times, time.Now())
+	f.bodies = append(f.bodies, body)
+	respond := f.responses[min(len(f.times), len(f.responses))-1]
+	return respond()
+}
+
+func (f *fakeServer) attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.times)
+}
+
+// gap returns the time waited before the attempt i, counted from 0.
+func (f *fakeServer) gap(i int) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.times[i].Sub(f.times[i-1])
+}
+
+func status(code int, header ...string) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		res := &http.Response{
+			StatusCode: code,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader("")),
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			res.Header.Set(header[i], header[i+1])
+		}
+		return res, nil
+	}
+}
+
+func newTestState(f *fakeServer, policy RetryPolicy) *State {
+	return &State{Transport: f, Retry: &policy}
+}
+
+var testPolicy = RetryPolicy{Attempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 3 * time.Second}
+
+func do(t *testing.T, s *State, method, body string) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "http://example.com/api", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestRetryBackoff(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusServiceUnavailable),
+		func() (*http.Response, error) { return nil, errors.New("connection reset") },
+		status(http.StatusOK),
+	}}
+	res := do(t, newTestState(f, testPolicy), http.MethodGet, "")
+	if res.StatusCode != http.StatusOK || f.attempts() != 3 {
+		t.Fatalf("got %d after %d attempts, want 200 after 3", res.StatusCode, f.attempts())
+	}
+	// the delay doubles, with up to half of it added as jitter
+	if gap := f.gap(1); gap < testPolicy.BaseDelay {
+		t.Errorf("waited %v before the first retry, want at least %v", gap, testPolicy.BaseDelay)
+	}
+	if gap := f.gap(2); gap < 2*testPolicy.BaseDelay {
+		t.Errorf("waited %v before the second retry, want at least %v", gap, 2*testPolicy.BaseDelay)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){status(http.StatusInternalServerError)}}
+	res := do(t, newTestState(f, testPolicy), http.MethodGet, "")
+	if res.StatusCode != http.StatusInternalServerError || f.attempts() != testPolicy.Attempts {
+		t.Fatalf("got %d after %d attempts, want 500 after %d", res.StatusCode, f.attempts(), testPolicy.Attempts)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusTooManyRequests, "Retry-After", "1"),
+		status(http.StatusOK),
+	}}
+	res := do(t, newTestState(f, testPolicy), http.MethodGet, "")
+	if res.StatusCode != http.StatusOK || f.attempts() != 2 {
+		t.Fatalf("got %d after %d attempts, want 200 after 2", res.StatusCode, f.attempts())
+	}
+	if gap := f.gap(1); gap < time.Second {
+		t.Fatalf("waited %v, want the second of Retry-After", gap)
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	// the date has a resolution of a second, two from now is over one
+	date := time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusServiceUnavailable, "Retry-After", date),
+		status(http.StatusOK),
+	}}
+	res := do(t, newTestState(f, testPolicy), http.MethodGet, "")
+	if res.StatusCode != http.StatusOK || f.attempts() != 2 {
+		t.Fatalf("got %d after %d attempts, want 200 after 2", res.StatusCode, f.attempts())
+	}
+	if gap := f.gap(1); gap < time.Second-100*time.Millisecond {
+		t.Fatalf("waited %v, want until the date of Retry-After", gap)
+	}
+}
+
+func TestRetryAfterBeyondMaxDelay(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusTooManyRequests, "Retry-After", "120"),
+		status(http.StatusOK),
+	}}
+	start := time.Now()
+	res := do(t, newTestState(f, testPolicy), http.MethodGet, "")
+	if res.StatusCode != http.StatusTooManyRequests || f.attempts() != 1 {
+		t.Fatalf("got %d after %d attempts, want the 429 right away", res.StatusCode, f.attempts())
+	}
+	if elapsed := time.Since(start); elapsed > testPolicy.MaxDelay {
+		t.Fatalf("returned after %v", elapsed)
+	}
+}
+
+func TestPostNotRetried(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusServiceUnavailable),
+		status(http.StatusOK),
+	}}
+	res := do(t, newTestState(f, testPolicy), http.MethodPost, `{"username":"alice"}`)
+	if res.StatusCode != http.StatusServiceUnavailable || f.attempts() != 1 {
+		t.Fatalf("got %d after %d attempts, want the 503 of the only attempt", res.StatusCode, f.attempts())
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	const body = `{"public_key":"abc"}`
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusBadGateway),
+		status(http.StatusBadGateway),
+		status(http.StatusNoContent),
+	}}
+	res := do(t, newTestState(f, testPolicy), http.MethodPut, body)
+	if res.StatusCode != http.StatusNoContent || f.attempts() != 3 {
+		t.Fatalf("got %d after %d attempts, want 204 after 3", res.StatusCode, f.attempts())
+	}
+	for i, got := range f.bodies {
+		if got != body {
+			t.Errorf("attempt %d sent %q, want %q", i+1, got, body)
+		}
+	}
+}
+
+func TestBodyWithoutGetBodyNotRetried(t *testing.T) {
+	f := &fakeServer{responses: []func() (*http.Response, error){
+		status(http.StatusServiceUnavailable),
+		status(http.StatusOK),
+	}}
+	req, err := http.NewRequest(http.MethodPut, "http://example.com/api", io.NopCloser(strings.NewReader("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := newTestState(f, testPolicy).httpClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if f.attempts() != 1 {
+		t.Fatalf("%d attempts with a body that cannot be replayed, want 1", f.attempts())
+	}
+}
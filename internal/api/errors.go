@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CodeUsernameTaken is the error code of a sign up with a username that
+// is already in use.
+const CodeUsernameTaken = "username_taken"
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// The sentinels match an *Error with errors.Is.
+var (
+	ErrUnauthorized      = errors.New("the server rejected the credentials")
+	ErrUsernameTaken     = errors.New("the username is already taken")
+	ErrRateLimited       = errors.New("too many requests")
+	ErrServerUnavailable = errors.New("the server is unavailable")
+)
+
+// Error is a request the server answered with an error status. Code and
+// Message come from the JSON body of the response, when it has one.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	// RetryAfter is how long the server asked to wait before retrying,
+	// zero when it did not say.
+	RetryAfter time.Duration
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("the server responded with status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrUsernameTaken:
+		return e.Code == CodeUsernameTaken
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// newError builds the error of a response with an unexpected status. The
+// body is read but not closed.
+func newError(res *http.Response) *Error {
+	e := &Error{StatusCode: res.StatusCode, RetryAfter: retryAfter(res.Header)}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	if err != nil || len(data) == 0 {
+		return e
+	}
+	// the server either sends {"code", "message"} or {"error"}, a plain
+	// text body is kept as the message but a proxy page is not
+	var body errorBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		if strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return e
+	}
+	e.Code = body.Code
+	e.Message = body.Message
+	if e.Message == "" {
+		e.Message = body.Error
+	}
+	return e
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type contactRequest struct {
+	Username string `json:"username"`
+}
+
+// HandlerListContacts returns the contacts stored on the server for the
+// logged in user.
+func (s *State) HandlerListContacts(ctx context.Context) ([]UserProfile, error) {
+	url := s.Server.BaseURL + s.Server.Contacts
+	req, err := http.NewRequestWithContext(ctx, "GET", url, bytes.NewReader([]byte{}))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("could not list the contacts: %w", newError(res))
+	}
+
+	var contacts []UserProfile
+	if err := json.NewDecoder(res.Body).Decode(&contacts); err != nil {
+		return nil, fmt.Errorf("could not parse the contact list: %w", err)
+	}
+	return contacts, nil
+}
+
+// HandlerAddContact stores username as a contact on the server and returns
+// its profile.
+func (s *State) HandlerAddContact(ctx context.Context, username string) (UserProfile, error) {
+	bodyReader, err := createReaderFromStruct(contactRequest{Username: username})
+	if err != nil {
+		return UserProfile{}, err
+	}
+
+	url := s.Server.BaseURL + s.Server.Contacts
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bodyReader)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return UserProfile{}, fmt.Errorf("could not add the contact: %w", newError(res))
+	}
+
+	var profile UserProfile
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return UserProfile{}, fmt.Errorf("could not parse the user profile: %w", err)
+	}
+	if len(profile.PublicKey) == 0 {
+		return UserProfile{}, fmt.Errorf("%s has not published a public key yet", username)
+	}
+	return profile, nil
+}
+
+func (s *State) HandlerRemoveContact(ctx context.Context, username string) error {
+	endpoint := s.Server.BaseURL + s.Server.Contacts + "/" + url.PathEscape(username)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return fmt.Errorf("could not remove the contact: %w", newError(res))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// HistoryPage is a page of the messages stored on the server for a room,
+// newest first. The messages are the events as they were sent, still
+// encrypted. NextCursor is empty on the last page.
+type HistoryPage struct {
+	Messages   []json.RawMessage `json:"messages"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+// HandlerRoomHistory returns up to limit messages of room sent before the
+// cursor of a previous page, or the most recent ones when cursor is empty.
+func (s *State) HandlerRoomHistory(ctx context.Context, room uuid.UUID, cursor string, limit int) (HistoryPage, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		query.Set("before", cursor)
+	}
+	endpoint := s.Server.BaseURL + s.Server.Rooms + "/" + room.String() + "/messages?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return HistoryPage{}, fmt.Errorf("could not fetch the room history: %w", newError(res))
+	}
+
+	var page HistoryPage
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		return HistoryPage{}, fmt.Errorf("could not parse the room history: %w", err)
+	}
+	return page, nil
+}
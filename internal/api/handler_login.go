@@ -30,7 +30,7 @@ func (s *State) HandlerLogin(ctx context.Context, username, password string) err
 	}
 	credentialsBuffer := bytes.NewBuffer(credentialsMarshal)
 
-	resLogin, err := makeLoginRequest(ctx, s.Server.BaseURL+s.Server.Login, credentialsBuffer)
+	resLogin, err := makeLoginRequest(ctx, s.httpClient(), s.Server.BaseURL+s.Server.Login, credentialsBuffer)
 	if err != nil {
 		return fmt.Errorf("failed while calling the login endpoint: %w", err)
 	}
@@ -38,13 +38,12 @@ func (s *State) HandlerLogin(ctx context.Context, username, password string) err
 	// assign login credentials to the state variable
 	s.User.UserID = resLogin.ID
 	s.User.Username = resLogin.Username
-	s.User.Token = resLogin.Token
-	s.User.RefreshToken = resLogin.RefreshToken
+	s.setTokens(resLogin.Token, resLogin.RefreshToken)
 
 	return nil
 }
 
-func makeLoginRequest(ctx context.Context, url string, credentials io.Reader) (responseVals, error) {
+func makeLoginRequest(ctx context.Context, client *http.Client, url string, credentials io.Reader) (responseVals, error) {
 	// create the request
 	req, err := http.NewRequestWithContext(ctx, "POST", url, credentials)
 	if err != nil {
@@ -53,15 +52,15 @@ func makeLoginRequest(ctx context.Context, url string, credentials io.Reader) (r
 	req.Header.Set("Content-Type", "application/json")
 
 	// make the request
-	client := &http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
 		return responseVals{}, err
 	}
+	defer res.Body.Close()
 
 	// check code status
 	if res.StatusCode > 201 {
-		return responseVals{}, fmt.Errorf("server responded with status on endpoint %s: %v", url, res.Status)
+		return responseVals{}, newError(res)
 	}
 
 	// parse the response
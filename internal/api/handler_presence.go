@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PresenceStatus is whether a user is connected and active.
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// Presence is the status of a user, with the custom message they set.
+type Presence struct {
+	Username string         `json:"username"`
+	Status   PresenceStatus `json:"status"`
+	Message  string         `json:"message,omitempty"`
+}
+
+// HandlerPresence returns the presence of the given users. The websocket
+// reports the changes, this is for the users we have not heard from.
+func (s *State) HandlerPresence(ctx context.Context, usernames []string) ([]Presence, error) {
+	query := url.Values{}
+	query.Set("users", strings.Join(usernames, ","))
+	endpoint := s.Server.BaseURL + s.Server.Presence + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("could not fetch the presence of the contacts: %w", newError(res))
+	}
+
+	var presence []Presence
+	if err := json.NewDecoder(res.Body).Decode(&presence); err != nil {
+		return nil, fmt.Errorf("could not parse the presence of the contacts: %w", err)
+	}
+	return presence, nil
+}
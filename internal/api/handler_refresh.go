@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrRefreshRejected = errors.New("the session expired, please log in again")
+
+type refreshResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokens asks the server for a new access token. The refresh is
+// skipped when another caller already replaced the token that expired.
+func (s *State) RefreshTokens(ctx context.Context, expiredToken string) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	if token, _ := s.tokens(); token != expiredToken {
+		return nil
+	}
+
+	url := s.Server.BaseURL + s.Server.Refresh
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	s.AddAuthTokensToHeader(&req.Header)
+
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrRefreshRejected
+	}
+	if res.StatusCode != 200 {
+		return fmt.Errorf("could not refresh the tokens: %w", newError(res))
+	}
+
+	var resVals refreshResponse
+	if err := json.NewDecoder(res.Body).Decode(&resVals); err != nil {
+		return err
+	}
+	s.setTokens(resVals.Token, resVals.RefreshToken)
+
+	s.tokensMu.RLock()
+	tokensChanged := s.tokensChanged
+	s.tokensMu.RUnlock()
+	if tokensChanged != nil {
+		tokensChanged(s.tokens())
+	}
+	return nil
+}
+
+// AccessToken returns the access token currently in use.
+func (s *State) AccessToken() string {
+	token, _ := s.tokens()
+	return token
+}
+
+// doWithAuth sends req with the auth tokens. When the server rejects the
+// access token, the tokens are refreshed and the request is retried once.
+func (s *State) doWithAuth(req *http.Request) (*http.Response, error) {
+	token := s.AccessToken()
+	s.AddAuthTokensToHeader(&req.Header)
+
+	client := s.httpClient()
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	discard(res)
+
+	if err := s.RefreshTokens(req.Context(), token); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	s.AddAuthTokensToHeader(&retry.Header)
+	return client.Do(retry)
+}
@@ -7,8 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/google/uuid"
 )
 
+// ErrNoPublicKey is returned for a user who never published an identity
+// key.
+var ErrNoPublicKey = errors.New("the user has not published a public key yet")
+
 type ValidationError struct {
 	message string
 }
@@ -17,11 +23,28 @@ func (e ValidationError) Error() string {
 	return e.message
 }
 
-func (s *State) HandlerCreateUser(ctx context.Context, username, password string) error {
+type newUser struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	PublicKey []byte `json:"public_key"`
+}
+
+type publicKeyBody struct {
+	PublicKey []byte `json:"public_key"`
+}
+
+// UserProfile is the public information the server shares about a user.
+type UserProfile struct {
+	ID        uuid.UUID `json:"id"`
+	Username  string    `json:"username"`
+	PublicKey []byte    `json:"public_key"`
+}
+
+func (s *State) HandlerCreateUser(ctx context.Context, username, password string, publicKey []byte) error {
 	if err := validateCredentials(username, password); err != nil {
 		return err
 	}
-	credentialsReader, err := createReaderFromStruct(loginCredentials{Username: username, Password: password})
+	credentialsReader, err := createReaderFromStruct(newUser{Username: username, Password: password, PublicKey: publicKey})
 	if err != nil {
 		return err
 	}
@@ -34,38 +57,78 @@ func (s *State) HandlerCreateUser(ctx context.Context, username, password string
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+	res, err := s.httpClient().Do(req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 201 {
-		return fmt.Errorf("could not create the user on endpoint %s: %v", url, res.StatusCode)
+		apiErr := newError(res)
+		// older servers answer a taken username with a bare conflict
+		if apiErr.StatusCode == http.StatusConflict && apiErr.Code == "" {
+			apiErr.Code = CodeUsernameTaken
+		}
+		return apiErr
 	}
 
 	return nil
 }
 
-func (s *State) HandlerCheckUserExists(ctx context.Context, username string) error {
+// HandlerCheckUserExists resolves the profile of username, including the
+// public identity key needed to encrypt messages for it.
+func (s *State) HandlerCheckUserExists(ctx context.Context, username string) (UserProfile, error) {
 	if len(username) == 0 {
-		return errors.New("the username cannot be empty")
+		return UserProfile{}, errors.New("the username cannot be empty")
 	}
 
 	url := s.Server.BaseURL + s.Server.Users + "/" + username
 	req, err := http.NewRequestWithContext(ctx, "GET", url, bytes.NewReader([]byte{}))
+	if err != nil {
+		return UserProfile{}, err
+	}
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return UserProfile{}, fmt.Errorf("could not look up %s: %w", username, newError(res))
+	}
+
+	var profile UserProfile
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return UserProfile{}, fmt.Errorf("could not parse the user profile: %w", err)
+	}
+	if len(profile.PublicKey) == 0 {
+		return UserProfile{}, fmt.Errorf("could not look up %s: %w", username, ErrNoPublicKey)
+	}
+
+	return profile, nil
+}
+
+// HandlerUploadPublicKey publishes the public identity key of the logged in
+// user, used when the server does not know the key of this device.
+func (s *State) HandlerUploadPublicKey(ctx context.Context, publicKey []byte) error {
+	bodyReader, err := createReaderFromStruct(publicKeyBody{PublicKey: publicKey})
 	if err != nil {
 		return err
 	}
-	s.AddAuthTokensToHeader(&req.Header)
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+	url := s.Server.BaseURL + s.Server.Keys
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bodyReader)
 	if err != nil {
 		return err
 	}
-	if res.StatusCode != 200 {
-		return fmt.Errorf("code status different from 200: %v", res.StatusCode)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.doWithAuth(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return fmt.Errorf("could not publish the public key: %w", newError(res))
 	}
 
 	return nil
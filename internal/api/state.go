@@ -1,7 +1,10 @@
 package api
 
 import (
+	"crypto/tls"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -9,6 +12,29 @@ import (
 type State struct {
 	User   UserInfo
 	Server ServerInfo
+	// TLS configures the connections to https and wss URLs, nil uses the
+	// system defaults.
+	TLS *tls.Config
+	// Transport sends the api requests, nil uses a transport with the TLS
+	// settings and the proxy of the environment.
+	Transport http.RoundTripper
+	// Timeout bounds each api request including its retries, zero uses
+	// DefaultTimeout.
+	Timeout time.Duration
+	// Retry is the retry policy of the idempotent requests, nil uses
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	clientOnce sync.Once
+	client     *http.Client
+
+	// tokensMu guards the tokens in User, they are replaced on refresh
+	// while other requests may be reading them.
+	tokensMu sync.RWMutex
+	// refreshMu makes concurrent requests share a single refresh.
+	refreshMu sync.Mutex
+	// tokensChanged is called with the new tokens after a refresh.
+	tokensChanged func(token, refreshToken string)
 }
 
 type UserInfo struct {
@@ -23,9 +49,55 @@ type ServerInfo struct {
 	WebsocketURL string
 	Login        string
 	Users        string
+	Keys         string
+	Refresh      string
+	Contacts     string
+	Rooms        string
+	Presence     string
 }
 
-func (s State) AddAuthTokensToHeader(header *http.Header) {
+func (s *State) AddAuthTokensToHeader(header *http.Header) {
+	s.tokensMu.RLock()
+	defer s.tokensMu.RUnlock()
 	header.Set("Auth", "Bearer "+s.User.Token)
 	header.Set("X-Refresh-Token", "Token "+s.User.RefreshToken)
 }
+
+func (s *State) tokens() (string, string) {
+	s.tokensMu.RLock()
+	defer s.tokensMu.RUnlock()
+	return s.User.Token, s.User.RefreshToken
+}
+
+// Tokens returns the access and refresh tokens currently in use.
+func (s *State) Tokens() (string, string) {
+	return s.tokens()
+}
+
+// RestoreSession resumes a login saved earlier, the access token may have
+// expired and is refreshed by the first request.
+func (s *State) RestoreSession(userID uuid.UUID, username, token, refreshToken string) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	s.User.UserID = userID
+	s.User.Username = username
+	s.User.Token = token
+	s.User.RefreshToken = refreshToken
+}
+
+// OnTokensChanged registers f to be called with the new tokens after every
+// refresh, nil stops the calls.
+func (s *State) OnTokensChanged(f func(token, refreshToken string)) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	s.tokensChanged = f
+}
+
+func (s *State) setTokens(token, refreshToken string) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	s.User.Token = token
+	if refreshToken != "" {
+		s.User.RefreshToken = refreshToken
+	}
+}
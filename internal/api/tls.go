@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const pinPrefix = "sha256/"
+
+var ErrPinMismatch = errors.New("the server certificate does not match any pinned public key")
+
+// NewTLSConfig returns the TLS settings used for the api and the websocket.
+// The certificates in the PEM bundle at caFile are trusted on top of the
+// system ones. When pins are given, the verified chain of the server must
+// contain one of the public keys, written as "sha256/" followed by the
+// base64 SHA-256 of the SubjectPublicKeyInfo.
+func NewTLSConfig(caFile string, pins []string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA bundle: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle %s", caFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if len(pins) > 0 {
+		hashes := make(map[[sha256.Size]byte]struct{}, len(pins))
+		for _, pin := range pins {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			hashes[hash] = struct{}{}
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if _, ok := hashes[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]; ok {
+						return nil
+					}
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+	return config, nil
+}
+
+// PublicKeyPin returns the pin of the public key of cert.
+func PublicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func parsePin(pin string) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(pin), pinPrefix)
+	if !ok {
+		return hash, fmt.Errorf("invalid pin %q: it must start with %q", pin, pinPrefix)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) != sha256.Size {
+		return hash, fmt.Errorf("invalid pin %q: it must be a base64 SHA-256 hash", pin)
+	}
+	copy(hash[:], data)
+	return hash, nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// otherPin is a well-formed pin of no key.
+var otherPin = "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+// newTLSServer starts a server with a self-signed certificate and writes
+// the certificate to a PEM bundle.
+func newTLSServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// the rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return server, caFile
+}
+
+// get sends a request through the api client built with the TLS settings.
+func get(t *testing.T, url, caFile string, pins []string) error {
+	t.Helper()
+	config, err := NewTLSConfig(caFile, pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &State{TLS: config, Retry: &RetryPolicy{Attempts: 1}}
+	res, err := s.httpClient().Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func TestTLSUnknownAuthority(t *testing.T) {
+	server, _ := newTLSServer(t)
+	if err := get(t, server.URL, "", nil); err == nil {
+		t.Fatal("the self-signed certificate was trusted without the CA bundle")
+	}
+}
+
+func TestTLSCABundle(t *testing.T) {
+	server, caFile := newTLSServer(t)
+	if err := get(t, server.URL, caFile, nil); err != nil {
+		t.Fatalf("the certificate of the CA bundle was rejected: %v", err)
+	}
+}
+
+func TestTLSPinMatch(t *testing.T) {
+	server, caFile := newTLSServer(t)
+	pins := []string{otherPin, PublicKeyPin(server.Certificate())}
+	if err := get(t, server.URL, caFile, pins); err != nil {
+		t.Fatalf("the pinned key was rejected: %v", err)
+	}
+}
+
+func TestTLSPinMismatch(t *testing.T) {
+	server, caFile := newTLSServer(t)
+	err := get(t, server.URL, caFile, []string{otherPin})
+	if !errors.Is(err, ErrPinMismatch) {
+		t.Fatalf("got %v, want ErrPinMismatch", err)
+	}
+}
+
+func TestInvalidPin(t *testing.T) {
+	for _, pin := range []string{"abc", "sha256/not base64", "sha256/" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewTLSConfig("", []string{pin}); err == nil {
+			t.Errorf("the pin %q was accepted", pin)
+		}
+	}
+}
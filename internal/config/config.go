@@ -0,0 +1,253 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/paths"
+)
+
+const appDir = "motbwa"
+
+// Config holds the settings of the client. They are read from the config
+// file, then overridden by the environment and the command-line flags.
+type Config struct {
+	// the server set at the top level is the default profile
+	Profile
+	Profiles   []Profile `json:"profiles"`
+	Endpoints  Endpoints `json:"endpoints"`
+	LogFile    string    `json:"log_file"`
+	Theme      string    `json:"theme"`
+	TimeFormat string    `json:"time_format"`
+	TimeZone   string    `json:"time_zone"`
+	// AwayAfter is the idle time after which the user shows as away, as
+	// in Go's time.ParseDuration, "0" never does.
+	AwayAfter string    `json:"away_after"`
+	Heartbeat Heartbeat `json:"heartbeat"`
+}
+
+// Heartbeat is the keepalive of the websocket connection: a ping every
+// Interval, as in Go's time.ParseDuration, and the connection is dropped
+// after MaxMissed pings without an answer.
+type Heartbeat struct {
+	Interval  string `json:"interval"`
+	MaxMissed int    `json:"max_missed"`
+}
+
+// Profile is a server and the account used on it.
+type Profile struct {
+	Name         string `json:"name"`
+	ServerURL    string `json:"server_url"`
+	WebsocketURL string `json:"websocket_url"`
+	TLS          TLS    `json:"tls"`
+	Username     string `json:"username"`
+}
+
+// Endpoints are the paths of the api, relative to the server URL.
+type Endpoints struct {
+	Login    string `json:"login"`
+	Users    string `json:"users"`
+	Keys     string `json:"keys"`
+	Refresh  string `json:"refresh"`
+	Contacts string `json:"contacts"`
+	Rooms    string `json:"rooms"`
+	Presence string `json:"presence"`
+}
+
+type TLS struct {
+	// CAFile is a PEM bundle of the authorities trusted on top of the
+	// system ones.
+	CAFile string `json:"ca_file"`
+	// Pins are the accepted public keys of the server certificate chain,
+	// as "sha256/<base64 of the SubjectPublicKeyInfo hash>".
+	Pins []string `json:"pins"`
+}
+
+func Default() Config {
+	return Config{
+		Profile: Profile{
+			Name:      paths.DefaultProfile,
+			ServerURL: "http://localhost:8080/",
+		},
+		Endpoints: Endpoints{
+			Login:    "api/login",
+			Users:    "api/users",
+			Keys:     "api/keys",
+			Refresh:  "api/refresh",
+			Contacts: "api/contacts",
+			Rooms:    "api/rooms",
+			Presence: "api/presence",
+		},
+		Theme:      "default",
+		TimeFormat: "15:04",
+		AwayAfter:  "5m",
+		Heartbeat: Heartbeat{
+			Interval:  "5s",
+			MaxMissed: 2,
+		},
+	}
+}
+
+// Dir returns the directory of the config file, under the XDG config
+// directory.
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appDir), nil
+}
+
+// DefaultPath returns the path of the config file used when none is given.
+func DefaultPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// Load reads the config file at path on top of the defaults. A missing file
+// is not an error unless required is set.
+func Load(path string, required bool) (Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return cfg, nil
+	} else if err != nil {
+		return Config{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the settings with the environment variables that are
+// set, the server ones apply to the default profile. PORT is kept for the
+// .env files that only set the local port, it is ignored once the config
+// file sets the server.
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	if port := getenv("PORT"); port != "" && c.ServerURL == Default().ServerURL && c.WebsocketURL == "" {
+		c.ServerURL = "http://localhost:" + port + "/"
+	}
+	overrides := map[string]*string{
+		"MOTBWA_SERVER_URL":    &c.ServerURL,
+		"MOTBWA_WEBSOCKET_URL": &c.WebsocketURL,
+		"MOTBWA_CA_FILE":       &c.TLS.CAFile,
+		"MOTBWA_LOG_FILE":      &c.LogFile,
+		"MOTBWA_THEME":         &c.Theme,
+		"MOTBWA_TIME_FORMAT":   &c.TimeFormat,
+		"MOTBWA_TIME_ZONE":     &c.TimeZone,
+		"MOTBWA_AWAY_AFTER":    &c.AwayAfter,
+		"MOTBWA_PING_INTERVAL": &c.Heartbeat.Interval,
+	}
+	for name, setting := range overrides {
+		if value := getenv(name); value != "" {
+			*setting = value
+		}
+	}
+	if pins := getenv("MOTBWA_PINS"); pins != "" {
+		c.TLS.Pins = SplitList(pins)
+	}
+	if maxMissed := getenv("MOTBWA_PING_MAX_MISSED"); maxMissed != "" {
+		n, err := strconv.Atoi(maxMissed)
+		if err != nil {
+			return fmt.Errorf("invalid MOTBWA_PING_MAX_MISSED %q: it must be a number", maxMissed)
+		}
+		c.Heartbeat.MaxMissed = n
+	}
+	return nil
+}
+
+// SplitList splits a comma separated list, dropping the empty items.
+func SplitList(list string) []string {
+	items := []string{}
+	for item := range strings.SplitSeq(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Resolve validates the profiles and fills the settings derived from
+// others, the log file defaults to the config directory.
+func (c *Config) Resolve() error {
+	c.Name = paths.DefaultProfile
+	names := map[string]bool{}
+	for _, profile := range c.AllProfiles() {
+		if names[profile.Name] {
+			return fmt.Errorf("there is more than one profile named %q", profile.Name)
+		}
+		names[profile.Name] = true
+	}
+	if err := c.Profile.resolve(); err != nil {
+		return err
+	}
+	for i := range c.Profiles {
+		if err := c.Profiles[i].resolve(); err != nil {
+			return fmt.Errorf("profile %q: %w", c.Profiles[i].Name, err)
+		}
+	}
+
+	if c.LogFile == "" {
+		dir, err := Dir()
+		if err != nil {
+			return err
+		}
+		c.LogFile = filepath.Join(dir, "motbwa.log")
+	}
+	return nil
+}
+
+// AllProfiles returns the default profile followed by the configured ones.
+func (c *Config) AllProfiles() []Profile {
+	return append([]Profile{c.Profile}, c.Profiles...)
+}
+
+// resolve validates the URLs, the websocket URL defaults to /ws on the
+// server.
+func (p *Profile) resolve() error {
+	if p.Name == "" {
+		return errors.New("the profile has no name")
+	}
+	server, err := url.Parse(p.ServerURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	if server.Scheme != "http" && server.Scheme != "https" {
+		return fmt.Errorf("invalid server URL %q: the scheme must be http or https", p.ServerURL)
+	}
+	if !strings.HasSuffix(server.Path, "/") {
+		server.Path += "/"
+	}
+	p.ServerURL = server.String()
+
+	if p.WebsocketURL == "" {
+		ws := *server
+		ws.Scheme = strings.Replace(server.Scheme, "http", "ws", 1)
+		ws.Path += "ws"
+		p.WebsocketURL = ws.String()
+	}
+	websocket, err := url.Parse(p.WebsocketURL)
+	if err != nil {
+		return fmt.Errorf("invalid websocket URL: %w", err)
+	}
+	if websocket.Scheme != "ws" && websocket.Scheme != "wss" {
+		return fmt.Errorf("invalid websocket URL %q: the scheme must be ws or wss", p.WebsocketURL)
+	}
+
+	// the TLS settings must not be silently ignored
+	if (p.TLS.CAFile != "" || len(p.TLS.Pins) > 0) && (server.Scheme != "https" || websocket.Scheme != "wss") {
+		return errors.New("the TLS settings need https and wss URLs")
+	}
+	return nil
+}
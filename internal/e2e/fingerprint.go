@@ -0,0 +1,54 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const fingerprintIterations = 1024
+
+// SafetyCode lets two users compare their identity keys out of band. Both
+// sides of a conversation compute the same code.
+type SafetyCode struct {
+	// Digits holds 12 groups of 5 digits.
+	Digits []string
+	// Digest is meant to be rendered as a visual block.
+	Digest [32]byte
+}
+
+func NewSafetyCode(me string, myKey []byte, peer string, peerKey []byte) SafetyCode {
+	mine := fingerprint(me, myKey)
+	theirs := fingerprint(peer, peerKey)
+	first, second := mine, theirs
+	if bytes.Compare(second[:], first[:]) < 0 {
+		first, second = second, first
+	}
+
+	digits := append(fingerprintDigits(first), fingerprintDigits(second)...)
+	return SafetyCode{
+		Digits: digits,
+		Digest: sha256.Sum256(append(first[:], second[:]...)),
+	}
+}
+
+// fingerprint hashes the key repeatedly to make finding a colliding key
+// more expensive.
+func fingerprint(name string, key []byte) [32]byte {
+	digest := sha256.Sum256(append([]byte(name+"|"), key...))
+	for range fingerprintIterations {
+		digest = sha256.Sum256(append(digest[:], key...))
+	}
+	return digest
+}
+
+func fingerprintDigits(digest [32]byte) []string {
+	groups := make([]string, 0, 6)
+	for i := range 6 {
+		chunk := make([]byte, 8)
+		copy(chunk[3:], digest[i*5:i*5+5])
+		groups = append(groups, fmt.Sprintf("%05d", binary.BigEndian.Uint64(chunk)%100000))
+	}
+	return groups
+}
@@ -0,0 +1,81 @@
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Identity holds the X25519 key pair of the local user.
+type Identity struct {
+	private *ecdh.PrivateKey
+}
+
+func NewIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate the identity key: %w", err)
+	}
+	return &Identity{private: key}, nil
+}
+
+func (i *Identity) PublicKey() *ecdh.PublicKey {
+	return i.private.PublicKey()
+}
+
+func ParsePublicKey(key []byte) (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadIdentity reads the private key stored at path, encrypted with key.
+// When there is no key the returned error satisfies
+// errors.Is(err, fs.ErrNotExist).
+func LoadIdentity(path string, key []byte) (*Identity, error) {
+	data, err := OpenFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	private, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key stored in %s: %w", path, err)
+	}
+	return &Identity{private: private}, nil
+}
+
+// Save writes the private key to path, encrypted with key.
+func (i *Identity) Save(path string, key []byte) error {
+	return SealFile(path, key, i.private.Bytes())
+}
+
+// LoadOrCreateIdentity returns the identity stored at path, generating and
+// saving a new one when there is none.
+func LoadOrCreateIdentity(path string, key []byte) (*Identity, error) {
+	identity, err := LoadIdentity(path, key)
+	if err == nil {
+		return identity, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	identity, err = NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := identity.Save(path, key); err != nil {
+		return nil, fmt.Errorf("could not save the identity key: %w", err)
+	}
+	return identity, nil
+}
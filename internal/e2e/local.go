@@ -0,0 +1,45 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SealFile writes data to path encrypted with key, the key of the local
+// data of the account. The name of the file is authenticated with it, so
+// one file cannot be passed off as another.
+func SealFile(path string, key, data []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, aead.Seal(nonce, nonce, data, []byte(filepath.Base(path))))
+}
+
+// OpenFile reads a file written with SealFile. When there is no file the
+// returned error satisfies errors.Is(err, fs.ErrNotExist).
+func OpenFile(path string, key []byte) ([]byte, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("truncated file %s", path)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, []byte(filepath.Base(path)))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %w", path, err)
+	}
+	return data, nil
+}
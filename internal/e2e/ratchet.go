@@ -0,0 +1,295 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// maxSkip bounds the number of message keys kept for messages that have
+// not arrived yet, so a malicious header cannot make us derive keys forever.
+const maxSkip = 1000
+
+const (
+	sessionInfo = "motbwa session v1"
+	chainInfo   = "motbwa chain v1"
+	rootInfo    = "motbwa ratchet v1"
+	messageInfo = "motbwa message keys v1"
+)
+
+var (
+	ErrTooManySkipped = errors.New("too many skipped messages")
+	ErrNoSendingChain = errors.New("the session has no sending chain")
+)
+
+type header struct {
+	DH []byte `json:"dh"`
+	PN uint32 `json:"pn"`
+	N  uint32 `json:"n"`
+}
+
+type envelope struct {
+	Header     header `json:"header"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// session is the Double Ratchet state shared with a single contact. All the
+// fields are exported so it can be persisted as JSON.
+type session struct {
+	PeerIdentity []byte            `json:"peer_identity"`
+	RootKey      []byte            `json:"root_key"`
+	SendingKey   []byte            `json:"sending_key"`
+	RemoteKey    []byte            `json:"remote_key"`
+	SendChain    []byte            `json:"send_chain"`
+	RecvChain    []byte            `json:"recv_chain"`
+	Ns           uint32            `json:"ns"`
+	Nr           uint32            `json:"nr"`
+	PN           uint32            `json:"pn"`
+	Skipped      map[string][]byte `json:"skipped"`
+	// SkippedOrder lists the keys of Skipped from the oldest.
+	SkippedOrder []string `json:"skipped_order"`
+}
+
+// newSession derives the initial state from the identity keys of both
+// sides. Each direction starts with its own chain, so either side can send
+// first. Only the side with the lowest name performs the first DH ratchet
+// step unprompted, this keeps both root keys in sync when both sides start
+// talking at the same time.
+func newSession(identity *Identity, me, peer string, peerKey *ecdh.PublicKey) (*session, error) {
+	shared, err := identity.private.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	first, second := me, peer
+	if second < first {
+		first, second = second, first
+	}
+	rootKey, err := hkdf.Key(sha256.New, shared, nil, sessionInfo+"|"+first+"|"+second, 32)
+	if err != nil {
+		return nil, err
+	}
+	sendChain, err := hkdf.Key(sha256.New, rootKey, nil, chainInfo+"|"+me, 32)
+	if err != nil {
+		return nil, err
+	}
+	recvChain, err := hkdf.Key(sha256.New, rootKey, nil, chainInfo+"|"+peer, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		PeerIdentity: peerKey.Bytes(),
+		RootKey:      rootKey,
+		SendingKey:   identity.private.Bytes(),
+		RemoteKey:    peerKey.Bytes(),
+		SendChain:    sendChain,
+		RecvChain:    recvChain,
+		Skipped:      make(map[string][]byte),
+	}
+	if me < peer {
+		if err := s.ratchetSending(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *session) clone() *session {
+	c := *s
+	c.Skipped = maps.Clone(s.Skipped)
+	c.SkippedOrder = slices.Clone(s.SkippedOrder)
+	return &c
+}
+
+func (s *session) encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	if s.SendChain == nil {
+		return nil, ErrNoSendingChain
+	}
+	sendingKey, err := ecdh.X25519().NewPrivateKey(s.SendingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var messageKey []byte
+	s.SendChain, messageKey = kdfChain(s.SendChain)
+	h := header{DH: sendingKey.PublicKey().Bytes(), PN: s.PN, N: s.Ns}
+	s.Ns++
+
+	ciphertext, err := sealMessage(messageKey, h, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Header: h, Ciphertext: ciphertext})
+}
+
+func (s *session) decrypt(data, additionalData []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("malformed envelope: %w", err)
+	}
+	h := env.Header
+
+	if key := skippedKey(h.DH, h.N); s.Skipped[key] != nil {
+		plaintext, err := openMessage(s.Skipped[key], h, env.Ciphertext, additionalData)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.Skipped, key)
+		s.SkippedOrder = slices.DeleteFunc(s.SkippedOrder, func(k string) bool { return k == key })
+		return plaintext, nil
+	}
+
+	if !bytes.Equal(h.DH, s.RemoteKey) {
+		if err := s.skipMessageKeys(h.PN); err != nil {
+			return nil, err
+		}
+		if err := s.ratchetReceiving(h.DH); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.skipMessageKeys(h.N); err != nil {
+		return nil, err
+	}
+
+	var messageKey []byte
+	s.RecvChain, messageKey = kdfChain(s.RecvChain)
+	s.Nr++
+	return openMessage(messageKey, h, env.Ciphertext, additionalData)
+}
+
+func (s *session) skipMessageKeys(until uint32) error {
+	if s.RecvChain == nil {
+		return nil
+	}
+	if until > s.Nr && until-s.Nr > maxSkip {
+		return ErrTooManySkipped
+	}
+	for s.Nr < until {
+		var messageKey []byte
+		s.RecvChain, messageKey = kdfChain(s.RecvChain)
+		key := skippedKey(s.RemoteKey, s.Nr)
+		s.Skipped[key] = messageKey
+		s.SkippedOrder = append(s.SkippedOrder, key)
+		s.Nr++
+	}
+	// drop the oldest keys when the cache grows too much
+	for len(s.Skipped) > maxSkip {
+		delete(s.Skipped, s.SkippedOrder[0])
+		s.SkippedOrder = s.SkippedOrder[1:]
+	}
+	return nil
+}
+
+// ratchetReceiving performs a full DH ratchet step after the peer announced
+// a new ratchet key.
+func (s *session) ratchetReceiving(remoteKey []byte) error {
+	remote, err := ecdh.X25519().NewPublicKey(remoteKey)
+	if err != nil {
+		return err
+	}
+	sendingKey, err := ecdh.X25519().NewPrivateKey(s.SendingKey)
+	if err != nil {
+		return err
+	}
+	shared, err := sendingKey.ECDH(remote)
+	if err != nil {
+		return err
+	}
+
+	s.RemoteKey = remoteKey
+	s.Nr = 0
+	s.RootKey, s.RecvChain, err = kdfRoot(s.RootKey, shared)
+	if err != nil {
+		return err
+	}
+	return s.ratchetSending()
+}
+
+// ratchetSending generates a new ratchet key and starts a new sending chain.
+func (s *session) ratchetSending() error {
+	remote, err := ecdh.X25519().NewPublicKey(s.RemoteKey)
+	if err != nil {
+		return err
+	}
+	sendingKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	shared, err := sendingKey.ECDH(remote)
+	if err != nil {
+		return err
+	}
+
+	s.SendingKey = sendingKey.Bytes()
+	s.PN = s.Ns
+	s.Ns = 0
+	s.RootKey, s.SendChain, err = kdfRoot(s.RootKey, shared)
+	return err
+}
+
+func kdfRoot(rootKey, shared []byte) ([]byte, []byte, error) {
+	out, err := hkdf.Key(sha256.New, shared, rootKey, rootInfo, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:32], out[32:], nil
+}
+
+func kdfChain(chainKey []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{0x01})
+	messageKey := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{0x02})
+	return mac.Sum(nil), messageKey
+}
+
+func sealMessage(messageKey []byte, h header, plaintext, additionalData []byte) ([]byte, error) {
+	aead, nonce, ad, err := messageCipher(messageKey, h, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func openMessage(messageKey []byte, h header, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, nonce, ad, err := messageCipher(messageKey, h, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
+
+// messageCipher expands a message key into the AEAD key and nonce. Every
+// message key is used once, so a derived nonce is safe.
+func messageCipher(messageKey []byte, h header, additionalData []byte) (cipher.AEAD, []byte, []byte, error) {
+	out, err := hkdf.Key(sha256.New, messageKey, nil, messageInfo, 44)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := newAEAD(out[:32])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encodedHeader, err := json.Marshal(h)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return aead, out[32:], append(bytes.Clone(additionalData), encodedHeader...), nil
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return base64.StdEncoding.EncodeToString(dh) + ":" + strconv.FormatUint(uint64(n), 10)
+}
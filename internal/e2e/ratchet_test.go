@@ -0,0 +1,128 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// newPair starts the sessions of alice and bob with each other.
+func newPair(t *testing.T) (alice, bob *session) {
+	t.Helper()
+	aliceID, bobID := newTestIdentity(t), newTestIdentity(t)
+	alice, err := newSession(aliceID, "alice", "bob", bobID.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err = newSession(bobID, "bob", "alice", aliceID.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, bob
+}
+
+func newTestIdentity(t *testing.T) *Identity {
+	t.Helper()
+	identity, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func mustEncrypt(t *testing.T, s *session, plaintext string) []byte {
+	t.Helper()
+	data, err := s.encrypt([]byte(plaintext), []byte("room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func expectDecrypt(t *testing.T, s *session, data []byte, want string) {
+	t.Helper()
+	got, err := s.decrypt(data, []byte("room"))
+	if err != nil {
+		t.Fatalf("decrypting %q: %v", want, err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	alice, bob := newPair(t)
+	for i := range 3 {
+		msg := fmt.Sprintf("from alice %d", i)
+		expectDecrypt(t, bob, mustEncrypt(t, alice, msg), msg)
+		msg = fmt.Sprintf("from bob %d", i)
+		expectDecrypt(t, alice, mustEncrypt(t, bob, msg), msg)
+	}
+}
+
+func TestBothSidesSendFirst(t *testing.T) {
+	alice, bob := newPair(t)
+	fromAlice := mustEncrypt(t, alice, "hi bob")
+	fromBob := mustEncrypt(t, bob, "hi alice")
+	expectDecrypt(t, alice, fromBob, "hi alice")
+	expectDecrypt(t, bob, fromAlice, "hi bob")
+
+	// the conversation goes on after the crossed messages
+	expectDecrypt(t, bob, mustEncrypt(t, alice, "still there?"), "still there?")
+	expectDecrypt(t, alice, mustEncrypt(t, bob, "yes"), "yes")
+}
+
+func TestOutOfOrder(t *testing.T) {
+	alice, bob := newPair(t)
+	first := mustEncrypt(t, alice, "first")
+	second := mustEncrypt(t, alice, "second")
+	third := mustEncrypt(t, alice, "third")
+
+	expectDecrypt(t, bob, third, "third")
+	expectDecrypt(t, bob, first, "first")
+	expectDecrypt(t, bob, second, "second")
+	if len(bob.Skipped) != 0 || len(bob.SkippedOrder) != 0 {
+		t.Fatalf("%d skipped keys left after every message arrived", len(bob.Skipped))
+	}
+}
+
+func TestSkippedAcrossRatchetSteps(t *testing.T) {
+	alice, bob := newPair(t)
+	lost := mustEncrypt(t, alice, "lost for a while")
+	expectDecrypt(t, bob, mustEncrypt(t, alice, "arrived"), "arrived")
+	expectDecrypt(t, alice, mustEncrypt(t, bob, "reply"), "reply")
+	expectDecrypt(t, bob, mustEncrypt(t, alice, "new chain"), "new chain")
+
+	expectDecrypt(t, bob, lost, "lost for a while")
+}
+
+func TestMaxSkip(t *testing.T) {
+	alice, bob := newPair(t)
+	var last []byte
+	for i := range maxSkip + 2 {
+		last = mustEncrypt(t, alice, fmt.Sprint(i))
+	}
+	if _, err := bob.clone().decrypt(last, []byte("room")); !errors.Is(err, ErrTooManySkipped) {
+		t.Fatalf("got %v, want ErrTooManySkipped", err)
+	}
+}
+
+func TestSkippedKeysDropTheOldest(t *testing.T) {
+	alice, bob := newPair(t)
+	messages := [][]byte{}
+	for i := range 1200 {
+		messages = append(messages, mustEncrypt(t, alice, fmt.Sprint(i)))
+	}
+	// two gaps of 600 keep more keys than maxSkip
+	expectDecrypt(t, bob, messages[600], "600")
+	expectDecrypt(t, bob, messages[1199], "1199")
+	if len(bob.Skipped) != maxSkip {
+		t.Fatalf("%d skipped keys kept, want %d", len(bob.Skipped), maxSkip)
+	}
+
+	if _, err := bob.decrypt(messages[0], []byte("room")); err == nil {
+		t.Fatal("the oldest skipped key was kept")
+	}
+	expectDecrypt(t, bob, messages[1198], "1198")
+	expectDecrypt(t, bob, messages[599], "599")
+}
@@ -0,0 +1,112 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Sessions keeps one ratchet session per contact and persists them to disk
+// after every change, so conversations survive restarts.
+type Sessions struct {
+	mu       sync.Mutex
+	identity *Identity
+	owner    string
+	path     string
+	key      []byte
+	sessions map[string]*session
+}
+
+// OpenSessions loads the sessions stored at path for the owner account,
+// encrypted with key. A missing file means there are no sessions yet.
+func OpenSessions(path string, key []byte, identity *Identity, owner string) (*Sessions, error) {
+	s := &Sessions{
+		identity: identity,
+		owner:    owner,
+		path:     path,
+		key:      key,
+		sessions: make(map[string]*session),
+	}
+
+	data, err := OpenFile(path, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return nil, fmt.Errorf("could not parse the sessions stored in %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Sessions) Identity() *Identity {
+	return s.identity
+}
+
+// Encrypt advances the sending chain of the session with peer and returns
+// the encoded message for it.
+func (s *Sessions) Encrypt(peer string, peerKey *ecdh.PublicKey, plaintext, additionalData []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.session(peer, peerKey)
+	if err != nil {
+		return nil, err
+	}
+	next := current.clone()
+	data, err := next.encrypt(plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return data, s.commit(peer, next)
+}
+
+// Decrypt opens a message sent by peer. The session is only updated when
+// the message is authentic.
+func (s *Sessions) Decrypt(peer string, peerKey *ecdh.PublicKey, data, additionalData []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.session(peer, peerKey)
+	if err != nil {
+		return nil, err
+	}
+	next := current.clone()
+	plaintext, err := next.decrypt(data, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, s.commit(peer, next)
+}
+
+// session returns the session with peer, starting a new one when there is
+// none or when the identity key of the peer changed.
+func (s *Sessions) session(peer string, peerKey *ecdh.PublicKey) (*session, error) {
+	if current, ok := s.sessions[peer]; ok && bytes.Equal(current.PeerIdentity, peerKey.Bytes()) {
+		return current, nil
+	}
+	return newSession(s.identity, s.owner, peer, peerKey)
+}
+
+func (s *Sessions) commit(peer string, next *session) error {
+	s.sessions[peer] = next
+	data, err := json.Marshal(s.sessions)
+	if err != nil {
+		return err
+	}
+	return SealFile(s.path, s.key, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
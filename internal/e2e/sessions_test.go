@@ -0,0 +1,120 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKey stands for the key of the local data.
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func openTestSessions(t *testing.T, path string, identity *Identity, owner string) *Sessions {
+	t.Helper()
+	sessions, err := OpenSessions(path, testKey, identity, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+func TestTamperedMessageLeavesTheSession(t *testing.T) {
+	dir := t.TempDir()
+	aliceID, bobID := newTestIdentity(t), newTestIdentity(t)
+	alice := openTestSessions(t, filepath.Join(dir, "alice.json"), aliceID, "alice")
+	bob := openTestSessions(t, filepath.Join(dir, "bob.json"), bobID, "bob")
+
+	data, err := alice.Encrypt("bob", bobID.PublicKey(), []byte("hello"), []byte("room"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.Ciphertext[0] ^= 1
+	tampered, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.Decrypt("alice", aliceID.PublicKey(), tampered, []byte("room")); err == nil {
+		t.Fatal("the tampered message was accepted")
+	}
+	if _, err := bob.Decrypt("alice", aliceID.PublicKey(), data, []byte("another room")); err == nil {
+		t.Fatal("the message was accepted with other additional data")
+	}
+	plaintext, err := bob.Decrypt("alice", aliceID.PublicKey(), data, []byte("room"))
+	if err != nil {
+		t.Fatalf("the authentic message was rejected after the tampered ones: %v", err)
+	}
+	if string(plaintext) != "hello" {
+		t.Fatalf("got %q, want %q", plaintext, "hello")
+	}
+}
+
+func TestSessionsPersist(t *testing.T) {
+	dir := t.TempDir()
+	alicePath, bobPath := filepath.Join(dir, "alice.json"), filepath.Join(dir, "bob.json")
+	aliceID, bobID := newTestIdentity(t), newTestIdentity(t)
+
+	alice := openTestSessions(t, alicePath, aliceID, "alice")
+	bob := openTestSessions(t, bobPath, bobID, "bob")
+	first, err := alice.Encrypt("bob", bobID.PublicKey(), []byte("first"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := alice.Encrypt("bob", bobID.PublicKey(), []byte("second"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Decrypt("alice", aliceID.PublicKey(), second, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// both sides restart, the skipped key of the first message is kept
+	alice = openTestSessions(t, alicePath, aliceID, "alice")
+	bob = openTestSessions(t, bobPath, bobID, "bob")
+	third, err := alice.Encrypt("bob", bobID.PublicKey(), []byte("third"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{first, third} {
+		if _, err := bob.Decrypt("alice", aliceID.PublicKey(), data, nil); err != nil {
+			t.Fatalf("decrypting after reopening the sessions: %v", err)
+		}
+	}
+	reply, err := bob.Encrypt("alice", aliceID.PublicKey(), []byte("reply"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := alice.Decrypt("bob", bobID.PublicKey(), reply, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "reply" {
+		t.Fatalf("got %q, want %q", plaintext, "reply")
+	}
+}
+
+func TestSessionsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alice.json")
+	aliceID, bobID := newTestIdentity(t), newTestIdentity(t)
+	alice := openTestSessions(t, path, aliceID, "alice")
+	if _, err := alice.Encrypt("bob", bobID.PublicKey(), []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("root_key")) {
+		t.Fatal("the sessions were saved in the clear")
+	}
+	if _, err := OpenSessions(path, bytes.Repeat([]byte{8}, 32), aliceID, "alice"); err == nil {
+		t.Fatal("the sessions were opened with another key")
+	}
+}
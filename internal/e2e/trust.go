@@ -0,0 +1,101 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+type TrustStatus int
+
+const (
+	Unverified TrustStatus = iota
+	Verified
+	// KeyChanged means the contact was verified but presented a new key.
+	KeyChanged
+)
+
+type trustEntry struct {
+	Key      []byte `json:"key"`
+	Verified bool   `json:"verified"`
+	Changed  bool   `json:"changed"`
+}
+
+// TrustStore remembers the identity key seen for every contact and whether
+// the user verified it.
+type TrustStore struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]trustEntry
+}
+
+func OpenTrustStore(path string) (*TrustStore, error) {
+	t := &TrustStore{path: path, entries: make(map[string]trustEntry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.entries); err != nil {
+		return nil, fmt.Errorf("could not parse the trust store %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Observe records the key currently published by name. A different key
+// than the stored one drops the verification.
+func (t *TrustStore) Observe(name string, key []byte) (TrustStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[name]
+	if ok && bytes.Equal(entry.Key, key) {
+		return entry.status(), nil
+	}
+
+	next := trustEntry{Key: key}
+	if ok {
+		next.Changed = entry.Verified || entry.Changed
+	}
+	t.entries[name] = next
+	return next.status(), t.save()
+}
+
+// Verify marks key as the verified key of name.
+func (t *TrustStore) Verify(name string, key []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entries[name] = trustEntry{Key: key, Verified: true}
+	return t.save()
+}
+
+func (t *TrustStore) Status(name string) TrustStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries[name].status()
+}
+
+func (e trustEntry) status() TrustStatus {
+	switch {
+	case e.Changed:
+		return KeyChanged
+	case e.Verified:
+		return Verified
+	}
+	return Unverified
+}
+
+func (t *TrustStore) save() error {
+	data, err := json.Marshal(t.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.path, data)
+}
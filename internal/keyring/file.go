@@ -0,0 +1,122 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+const keyIterations = 600_000
+
+type encryptedSession struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// fileStore keeps the session in a file encrypted with a key derived from
+// a passphrase, for the systems without a Secret Service.
+type fileStore struct {
+	path       string
+	passphrase string
+}
+
+func NewFileStore(path, passphrase string) Store {
+	return fileStore{path: path, passphrase: passphrase}
+}
+
+// FileExists reports whether a session was saved at path.
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (f fileStore) Load() (Session, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Session{}, ErrNoSession
+	} else if err != nil {
+		return Session{}, err
+	}
+
+	var encrypted encryptedSession
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return Session{}, err
+	}
+	aead, err := f.aead(encrypted.Salt)
+	if err != nil {
+		return Session{}, err
+	}
+	plaintext, err := aead.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return Session{}, ErrWrongPassphrase
+	}
+
+	var session Session
+	if err := json.Unmarshal(plaintext, &session); err != nil {
+		return Session{}, err
+	}
+	if err := session.validate(); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (f fileStore) Save(session Session) error {
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := f.aead(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(encryptedSession{
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f fileStore) Delete() error {
+	err := os.Remove(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f fileStore) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, f.passphrase, salt, keyIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,39 @@
+package keyring
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoSession         = errors.New("there is no saved session")
+	ErrWrongPassphrase   = errors.New("the passphrase does not unlock the saved session")
+	errSessionIncomplete = errors.New("the saved session is incomplete")
+)
+
+// Session is what resumes a login without the password: the tokens and the
+// key of the local message history, which is unwrapped with the password.
+type Session struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Username     string    `json:"username"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	HistoryKey   []byte    `json:"history_key"`
+}
+
+// Store keeps the session of a profile between launches.
+type Store interface {
+	// Load returns ErrNoSession when nothing was saved.
+	Load() (Session, error)
+	Save(Session) error
+	// Delete wipes the saved session, it is not an error when there is none.
+	Delete() error
+}
+
+func (s Session) validate() error {
+	if s.Username == "" || s.RefreshToken == "" || len(s.HistoryKey) == 0 {
+		return errSessionIncomplete
+	}
+	return nil
+}
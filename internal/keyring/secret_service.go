@@ -0,0 +1,116 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	secretTool     = "secret-tool"
+	secretService  = "motbwa"
+	secretTypeAttr = "session"
+)
+
+// probeTimeout bounds the wait for the Secret Service to answer the probe,
+// a missing D-Bus session can otherwise hang until it times out.
+const probeTimeout = 3 * time.Second
+
+// SecretServiceAvailable reports whether the Secret Service of the desktop
+// can be reached through the secret-tool command of libsecret. The command
+// is installed on many headless machines without a D-Bus session or a
+// keyring, so the service is probed with a lookup once. The first call
+// blocks until the probe answers, up to probeTimeout.
+func SecretServiceAvailable() bool {
+	return secretServiceProbe()
+}
+
+var secretServiceProbe = sync.OnceValue(func() bool {
+	if _, err := exec.LookPath(secretTool); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, secretTool, "lookup", "service", secretService, "type", "probe")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// a lookup without match exits with an error but reports nothing
+	var exitErr *exec.ExitError
+	if err == nil || errors.As(err, &exitErr) && ctx.Err() == nil && stderr.Len() == 0 {
+		return true
+	}
+	log.Printf("the Secret Service is not available: %v %s", err, strings.TrimSpace(stderr.String()))
+	return false
+})
+
+// secretServiceStore keeps the session in the keyring of the desktop, under
+// the attributes of the profile.
+type secretServiceStore struct {
+	profile string
+}
+
+func NewSecretService(profile string) Store {
+	return secretServiceStore{profile: profile}
+}
+
+func (s secretServiceStore) attributes() []string {
+	return []string{"service", secretService, "type", secretTypeAttr, "profile", s.profile}
+}
+
+func (s secretServiceStore) Load() (Session, error) {
+	out, err := s.run(nil, "lookup")
+	// lookup exits with an error and no output when nothing matches
+	if err != nil && len(out) == 0 {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return Session{}, ErrNoSession
+		}
+		return Session{}, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(out, &session); err != nil {
+		return Session{}, fmt.Errorf("invalid session in the keyring: %w", err)
+	}
+	if err := session.validate(); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (s secretServiceStore) Save(session Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	label := fmt.Sprintf("motbwa session (%s)", s.profile)
+	_, err = s.run(data, "store", "--label="+label)
+	return err
+}
+
+func (s secretServiceStore) Delete() error {
+	_, err := s.run(nil, "clear")
+	return err
+}
+
+// run calls secret-tool with the attributes of the profile, the secret is
+// passed through stdin so it never shows in the process list.
+func (s secretServiceStore) run(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(secretTool, append(args, s.attributes()...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return out, fmt.Errorf("%s %s: %s", secretTool, args[0], strings.TrimSpace(stderr.String()))
+	}
+	return out, err
+}
@@ -0,0 +1,55 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const appName = "motbwa"
+
+// DefaultProfile is the profile of the server set at the top level of the
+// config.
+const DefaultProfile = "default"
+
+// ProfileDir returns the directory holding the local data of a profile,
+// creating it when it does not exist yet. The data of the default profile is
+// kept at the top level, where it was stored before profiles existed.
+func ProfileDir(profile string) (string, error) {
+	if !validName(profile) {
+		return "", fmt.Errorf("invalid profile name for a local directory: %q", profile)
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, appName)
+	if profile != DefaultProfile {
+		dir = filepath.Join(base, appName, "profiles", profile)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// AccountDir returns the directory holding the local data of an account of
+// a profile, creating it when it does not exist yet.
+func AccountDir(profile, username string) (string, error) {
+	if !validName(username) {
+		return "", fmt.Errorf("invalid username for a local directory: %q", username)
+	}
+	base, err := ProfileDir(profile)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "accounts", username)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
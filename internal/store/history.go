@@ -0,0 +1,382 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/google/uuid"
+)
+
+const (
+	checkFile = "check"
+	roomsFile = "rooms"
+
+	keyIterations = 600_000
+	checkValue    = "motbwa history v1"
+	maxLineSize   = 1 << 20
+)
+
+var ErrWrongPassphrase = errors.New("the passphrase does not unlock the local history")
+
+type record struct {
+	ID      uuid.UUID `json:"id"`
+	Sender  string    `json:"sender"`
+	Content string    `json:"content"`
+	Date    time.Time `json:"date"`
+	Room    uuid.UUID `json:"room"`
+	Edited  bool      `json:"edited,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// History stores the messages of an account on disk, one append only file
+// per room that is only rewritten to edit or delete a message. Every line
+// is encrypted on its own so a page can be read without decrypting the
+// whole file.
+type History struct {
+	dir  string
+	aead cipher.AEAD
+	// stored holds the IDs of the messages of each room, read on the first
+	// append to the room
+	stored map[uuid.UUID]map[uuid.UUID]bool
+}
+
+// OpenHistory opens the history in dir with the key from UnlockKey. A key
+// that does not match the one used before returns ErrWrongPassphrase.
+func OpenHistory(dir string, key []byte) (*History, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	h := &History{dir: dir, aead: aead, stored: map[uuid.UUID]map[uuid.UUID]bool{}}
+
+	checkPath := filepath.Join(dir, checkFile)
+	data, err := os.ReadFile(checkPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, h.writeEncrypted(checkPath, []byte(checkValue))
+	} else if err != nil {
+		return nil, err
+	}
+	value, err := h.open(bytes.TrimSpace(data), nil)
+	if err != nil || string(value) != checkValue {
+		return nil, ErrWrongPassphrase
+	}
+	return h, nil
+}
+
+// Append stores the messages in the history of their rooms, in date
+// order. Messages older than the last stored one, like the pages of the
+// server scrollback, are inserted in place and the room file is rewritten.
+// The messages already stored are skipped.
+func (h *History) Append(messages ...ws.Message) error {
+	byRoom := map[uuid.UUID][]ws.Message{}
+	for _, msg := range messages {
+		byRoom[msg.Room] = append(byRoom[msg.Room], msg)
+	}
+	for room, messages := range byRoom {
+		slices.SortStableFunc(messages, func(a, b ws.Message) int {
+			return a.Date.Compare(b.Date)
+		})
+		if err := h.appendToRoom(room, messages); err != nil {
+			// the IDs are read again, the messages may not be stored
+			delete(h.stored, room)
+			return err
+		}
+	}
+	return nil
+}
+
+// appendToRoom stores messages, sorted by date, in the history of room.
+func (h *History) appendToRoom(room uuid.UUID, messages []ws.Message) error {
+	lines, err := h.readLines(room)
+	if err != nil {
+		return err
+	}
+	stored, err := h.storedIDs(room, lines)
+	if err != nil {
+		return err
+	}
+	messages = slices.DeleteFunc(messages, func(msg ws.Message) bool {
+		if stored[msg.ID] {
+			return true
+		}
+		stored[msg.ID] = true
+		return false
+	})
+	if len(messages) == 0 {
+		return nil
+	}
+
+	newLines := make([][]byte, 0, len(messages))
+	for _, msg := range messages {
+		line, err := h.sealRecord(record{
+			ID:      msg.ID,
+			Sender:  msg.Sender,
+			Content: msg.Content,
+			Date:    msg.Date,
+			Room:    msg.Room,
+		}, room)
+		if err != nil {
+			return err
+		}
+		newLines = append(newLines, line)
+	}
+
+	// walk back from the end until every message found its place, only
+	// those lines are decrypted
+	merged := [][]byte{}
+	end := len(lines)
+	for i := len(messages) - 1; i >= 0; i-- {
+		for end > 0 {
+			r, err := h.record(lines[end-1], room)
+			if err != nil {
+				return err
+			}
+			if !r.Date.After(messages[i].Date) {
+				break
+			}
+			merged = append(merged, lines[end-1])
+			end--
+		}
+		merged = append(merged, newLines[i])
+	}
+	slices.Reverse(merged)
+
+	// nothing stored is newer, the lines are only appended
+	if end == len(lines) {
+		f, err := os.OpenFile(h.roomPath(room), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(append(bytes.Join(merged, []byte{'\n'}), '\n'))
+		return err
+	}
+	return h.writeLines(room, append(lines[:end], merged...))
+}
+
+// storedIDs returns the IDs of the messages in lines, the history of room.
+// They are decrypted once, the appends keep the set up to date.
+func (h *History) storedIDs(room uuid.UUID, lines [][]byte) (map[uuid.UUID]bool, error) {
+	if ids, ok := h.stored[room]; ok {
+		return ids, nil
+	}
+	ids := make(map[uuid.UUID]bool, len(lines))
+	for _, line := range lines {
+		r, err := h.record(line, room)
+		if err != nil {
+			return nil, err
+		}
+		ids[r.ID] = true
+	}
+	h.stored[room] = ids
+	return ids, nil
+}
+
+// Page returns up to limit messages of room, oldest first, leaving out the
+// skip most recent ones. Only the lines of the page are decrypted.
+func (h *History) Page(room uuid.UUID, skip, limit int) ([]ws.Message, error) {
+	lines, err := h.readLines(room)
+	if err != nil {
+		return nil, err
+	}
+
+	end := max(len(lines)-skip, 0)
+	start := max(end-limit, 0)
+	messages := make([]ws.Message, 0, end-start)
+	for _, line := range lines[start:end] {
+		r, err := h.record(line, room)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, ws.Message{
+			ID:      r.ID,
+			Sender:  r.Sender,
+			Content: r.Content,
+			Date:    r.Date,
+			Room:    r.Room,
+			Edited:  r.Edited,
+			Deleted: r.Deleted,
+		})
+	}
+	return messages, nil
+}
+
+// Edit replaces the content of the message id of room, when it was sent by
+// sender, and marks it as edited.
+func (h *History) Edit(room, id uuid.UUID, sender, content string) error {
+	return h.rewrite(room, id, sender, func(r *record) {
+		r.Content = content
+		r.Edited = true
+	})
+}
+
+// Delete replaces the message id of room, when it was sent by sender, with
+// a tombstone that keeps its place in the history.
+func (h *History) Delete(room, id uuid.UUID, sender string) error {
+	return h.rewrite(room, id, sender, func(r *record) {
+		r.Content = ""
+		r.Edited = false
+		r.Deleted = true
+	})
+}
+
+// rewrite applies change to the record of the message and replaces the
+// room file with the result. The other lines are kept as they are, the
+// most recent ones are searched first.
+func (h *History) rewrite(room, id uuid.UUID, sender string, change func(*record)) error {
+	lines, err := h.readLines(room)
+	if err != nil {
+		return err
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		r, err := h.record(lines[i], room)
+		if err != nil {
+			return err
+		}
+		if r.ID != id {
+			continue
+		}
+		// only the sender changes a message, and a tombstone stays
+		if r.Sender != sender || r.Deleted {
+			return nil
+		}
+
+		change(&r)
+		if lines[i], err = h.sealRecord(r, room); err != nil {
+			return err
+		}
+		return h.writeLines(room, lines)
+	}
+	return nil
+}
+
+// Rooms returns the rooms saved with SaveRooms.
+func (h *History) Rooms() ([]ws.RoomState, error) {
+	data, err := os.ReadFile(filepath.Join(h.dir, roomsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	plaintext, err := h.open(bytes.TrimSpace(data), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rooms []ws.RoomState
+	if err := json.Unmarshal(plaintext, &rooms); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+func (h *History) SaveRooms(rooms []ws.RoomState) error {
+	data, err := json.Marshal(rooms)
+	if err != nil {
+		return err
+	}
+	return h.writeEncrypted(filepath.Join(h.dir, roomsFile), data)
+}
+
+func (h *History) readLines(room uuid.UUID) ([][]byte, error) {
+	f, err := os.Open(h.roomPath(room))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			lines = append(lines, bytes.Clone(scanner.Bytes()))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// record decrypts a line of the history of room.
+func (h *History) record(line []byte, room uuid.UUID) (record, error) {
+	data, err := h.open(line, room[:])
+	if err != nil {
+		return record{}, fmt.Errorf("corrupted history of room %s: %w", room, err)
+	}
+	var r record
+	return r, json.Unmarshal(data, &r)
+}
+
+func (h *History) sealRecord(r record, room uuid.UUID) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return h.seal(data, room[:])
+}
+
+// writeLines replaces the history of room with lines.
+func (h *History) writeLines(room uuid.UUID, lines [][]byte) error {
+	path := h.roomPath(room)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(bytes.Join(lines, []byte{'\n'}), '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (h *History) roomPath(room uuid.UUID) string {
+	return filepath.Join(h.dir, room.String()+".log")
+}
+
+func (h *History) writeEncrypted(path string, data []byte) error {
+	line, err := h.seal(data, nil)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, line, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// seal encrypts data into a base64 line.
+func (h *History) seal(data, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, h.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := h.aead.Seal(nonce, nonce, data, additionalData)
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(line, sealed)
+	return line, nil
+}
+
+func (h *History) open(line, additionalData []byte) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[:n]
+	if len(sealed) < h.aead.NonceSize() {
+		return nil, errors.New("truncated record")
+	}
+	nonce, ciphertext := sealed[:h.aead.NonceSize()], sealed[h.aead.NonceSize():]
+	return h.aead.Open(nil, nonce, ciphertext, additionalData)
+}
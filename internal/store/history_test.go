@@ -0,0 +1,216 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/google/uuid"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{1}, 32)
+	testRoom = uuid.MustParse("8f1f0b5e-2d3c-4a6b-9e7f-0a1b2c3d4e5f")
+	start    = time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC)
+)
+
+func openTestHistory(t *testing.T, dir string) *History {
+	t.Helper()
+	h, err := OpenHistory(dir, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// message returns the message n of testRoom, sent n minutes after start.
+func message(n int) ws.Message {
+	return ws.Message{
+		ID:      uuid.NewSHA1(testRoom, []byte{byte(n)}),
+		Sender:  "alice",
+		Content: string(rune('a' + n)),
+		Date:    start.Add(time.Duration(n) * time.Minute),
+		Room:    testRoom,
+	}
+}
+
+func messages(ns ...int) []ws.Message {
+	out := make([]ws.Message, 0, len(ns))
+	for _, n := range ns {
+		out = append(out, message(n))
+	}
+	return out
+}
+
+// contents returns the content of every message of testRoom, oldest first.
+func contents(t *testing.T, h *History) string {
+	t.Helper()
+	page, err := h.Page(testRoom, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	for _, msg := range page {
+		b.WriteString(msg.Content)
+	}
+	return b.String()
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]int
+		want    string
+	}{
+		{name: "in order", batches: [][]int{{0, 1}, {2}}, want: "abc"},
+		{name: "unsorted batch", batches: [][]int{{2, 0, 1}}, want: "abc"},
+		{name: "older page", batches: [][]int{{3, 4}, {0, 1}}, want: "abde"},
+		{name: "between the stored ones", batches: [][]int{{0, 3}, {1, 2}, {4}}, want: "abcde"},
+		{name: "already stored", batches: [][]int{{0, 1, 2}, {1, 2, 3}}, want: "abcd"},
+		{name: "twice in a batch", batches: [][]int{{0, 1, 1, 0}}, want: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := openTestHistory(t, t.TempDir())
+			for _, batch := range tt.batches {
+				if err := h.Append(messages(batch...)...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := contents(t, h); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendAfterReopening(t *testing.T) {
+	dir := t.TempDir()
+	if err := openTestHistory(t, dir).Append(messages(0, 1)...); err != nil {
+		t.Fatal(err)
+	}
+	// the scrollback fetched again after a restart
+	h := openTestHistory(t, dir)
+	if err := h.Append(messages(0, 1, 2)...); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, h); got != "abc" {
+		t.Fatalf("got %q, want %q", got, "abc")
+	}
+}
+
+func TestPage(t *testing.T) {
+	h := openTestHistory(t, t.TempDir())
+	if err := h.Append(messages(0, 1, 2, 3, 4)...); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		skip, limit int
+		want        []ws.Message
+	}{
+		{skip: 0, limit: 2, want: messages(3, 4)},
+		{skip: 2, limit: 2, want: messages(1, 2)},
+		{skip: 4, limit: 2, want: messages(0)},
+		{skip: 5, limit: 2, want: []ws.Message{}},
+	}
+	for _, tt := range tests {
+		page, err := h.Page(testRoom, tt.skip, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		equal := slices.EqualFunc(page, tt.want, func(a, b ws.Message) bool {
+			return a.ID == b.ID && a.Content == b.Content && a.Date.Equal(b.Date)
+		})
+		if !equal {
+			t.Errorf("skip %d, limit %d: got %v, want %v", tt.skip, tt.limit, page, tt.want)
+		}
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(h *History) error
+		want   ws.Message
+	}{
+		{
+			name:   "edit",
+			change: func(h *History) error { return h.Edit(testRoom, message(1).ID, "alice", "edited") },
+			want:   ws.Message{Content: "edited", Edited: true},
+		},
+		{
+			name:   "edit by another sender",
+			change: func(h *History) error { return h.Edit(testRoom, message(1).ID, "mallory", "edited") },
+			want:   ws.Message{Content: "b"},
+		},
+		{
+			name:   "delete",
+			change: func(h *History) error { return h.Delete(testRoom, message(1).ID, "alice") },
+			want:   ws.Message{Deleted: true},
+		},
+		{
+			name: "edit a tombstone",
+			change: func(h *History) error {
+				if err := h.Delete(testRoom, message(1).ID, "alice"); err != nil {
+					return err
+				}
+				return h.Edit(testRoom, message(1).ID, "alice", "edited")
+			},
+			want: ws.Message{Deleted: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := openTestHistory(t, t.TempDir())
+			if err := h.Append(messages(0, 1, 2)...); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.change(h); err != nil {
+				t.Fatal(err)
+			}
+			page, err := h.Page(testRoom, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != 3 || page[0].Content != "a" || page[2].Content != "c" {
+				t.Fatalf("the other messages changed: %v", page)
+			}
+			got := page[1]
+			if got.Content != tt.want.Content || got.Edited != tt.want.Edited || got.Deleted != tt.want.Deleted {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenHistoryWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	openTestHistory(t, dir)
+	if _, err := OpenHistory(dir, bytes.Repeat([]byte{2}, 32)); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("got %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	dir := t.TempDir()
+	rooms := []ws.RoomState{
+		{ID: testRoom, Name: "general", Members: []string{"alice", "bob"}},
+		{ID: uuid.New(), Name: "@bob", Peer: "bob"},
+	}
+	if err := openTestHistory(t, dir).SaveRooms(rooms); err != nil {
+		t.Fatal(err)
+	}
+	got, err := openTestHistory(t, dir).Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal := slices.EqualFunc(got, rooms, func(a, b ws.RoomState) bool {
+		return a.ID == b.ID && a.Name == b.Name && a.Peer == b.Peer && slices.Equal(a.Members, b.Members)
+	})
+	if !equal {
+		t.Fatalf("got %+v, want %+v", got, rooms)
+	}
+}
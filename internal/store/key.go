@@ -0,0 +1,119 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const keyFile = "key"
+
+// wrappedKey is the random key of the history sealed with a key derived
+// from the passphrase, so a new passphrase only wraps it again.
+type wrappedKey struct {
+	Salt []byte `json:"salt"`
+	Key  []byte `json:"key"`
+}
+
+// UnlockKey returns the key of the history in dir, unwrapped with the
+// passphrase. The first time a random key is created. A passphrase that
+// does not unwrap the key returns ErrWrongPassphrase.
+func UnlockKey(dir, passphrase string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, keyFile))
+	if err == nil {
+		var wrapped wrappedKey
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("corrupted history key: %w", err)
+		}
+		return wrapped.unwrap(passphrase)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, wrapKey(dir, key, passphrase)
+}
+
+// Rekey wraps the key of the history in dir with a new passphrase, after
+// the passphrase it was wrapped with changed.
+func Rekey(dir, oldPassphrase, newPassphrase string) ([]byte, error) {
+	key, err := UnlockKey(dir, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return key, wrapKey(dir, key, newPassphrase)
+}
+
+func wrapKey(dir string, key []byte, passphrase string) error {
+	wrapped := wrappedKey{Salt: make([]byte, 16)}
+	if _, err := rand.Read(wrapped.Salt); err != nil {
+		return err
+	}
+	aead, err := wrapped.cipher(passphrase)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	wrapped.Key = aead.Seal(nonce, nonce, key, []byte(keyFile))
+
+	data, err := json.Marshal(wrapped)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, keyFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (w wrappedKey) unwrap(passphrase string) ([]byte, error) {
+	aead, err := w.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Key) < aead.NonceSize() {
+		return nil, errors.New("corrupted history key")
+	}
+	nonce, ciphertext := w.Key[:aead.NonceSize()], w.Key[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, ciphertext, []byte(keyFile))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+func (w wrappedKey) cipher(passphrase string) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, w.Salt, keyIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func unlock(t *testing.T, dir, passphrase string) []byte {
+	t.Helper()
+	key, err := UnlockKey(dir, passphrase)
+	if err != nil {
+		t.Fatalf("unlocking with %q: %v", passphrase, err)
+	}
+	return key
+}
+
+func TestUnlockKey(t *testing.T) {
+	dir := t.TempDir()
+	key := unlock(t, dir, "first password")
+	if len(key) != 32 {
+		t.Fatalf("got a key of %d bytes, want 32", len(key))
+	}
+	if again := unlock(t, dir, "first password"); !bytes.Equal(again, key) {
+		t.Fatal("the second unlock returned another key")
+	}
+	if _, err := UnlockKey(dir, "other password"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("got %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		err      error
+		// unlocks is the passphrase that unlocks the key afterwards
+		unlocks string
+	}{
+		{name: "previous password", previous: "old password", unlocks: "new password"},
+		{name: "wrong password", previous: "guessed password", err: ErrWrongPassphrase, unlocks: "old password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			key := unlock(t, dir, "old password")
+
+			rekeyed, err := Rekey(dir, tt.previous, "new password")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && !bytes.Equal(rekeyed, key) {
+				t.Fatal("the key changed, the history could not be read any more")
+			}
+			if got := unlock(t, dir, tt.unlocks); !bytes.Equal(got, key) {
+				t.Fatal("the key changed after the rekey")
+			}
+			for _, other := range []string{"old password", "new password"} {
+				if other == tt.unlocks {
+					continue
+				}
+				if _, err := UnlockKey(dir, other); !errors.Is(err, ErrWrongPassphrase) {
+					t.Fatalf("%q still unlocks the key: %v", other, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlockCorruptedKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, keyFile), []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnlockKey(dir, "password"); err == nil || errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("got %v, want an error about the corrupted key", err)
+	}
+}
@@ -0,0 +1,193 @@
+package ui
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/CTSDM/motbwa-tui/internal/keyring"
+	"github.com/CTSDM/motbwa-tui/internal/paths"
+	"github.com/CTSDM/motbwa-tui/internal/store"
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	identityFile = "identity.key"
+	sessionsFile = "sessions.json"
+	trustFile    = "trust.json"
+	outboxFile   = "outbox.json"
+	historyDir   = "history"
+)
+
+func identityPath(profile, username string) (string, error) {
+	dir, err := paths.AccountDir(profile, username)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, identityFile), nil
+}
+
+// account is the local data of the logged in user.
+type account struct {
+	sessions *e2e.Sessions
+	trust    *e2e.TrustStore
+	outbox   *ws.Outbox
+	history  *store.History
+}
+
+// loggedIn is the result of a login, with the account loaded. warning
+// reports a failure that did not prevent the login. historyLocked is set
+// when the password no longer unlocks the key of the local data, the
+// account is loaded once the previous password unlocked it.
+type loggedIn struct {
+	account       account
+	historyLocked bool
+	password      string
+	remember      keyring.Store
+	warning       error
+	err           error
+}
+
+type signedUp struct {
+	err error
+}
+
+// login logs in and loads the account of the user. With a store, the
+// session is saved there to skip the login next time.
+func login(ctx context.Context, state *api.State, profile, username, password string, remember keyring.Store) tea.Msg {
+	if err := state.HandlerLogin(ctx, username, password); err != nil {
+		return loggedIn{err: err}
+	}
+	// the password changed since the key was created, the user is asked
+	// for the previous one once logged in
+	historyKey, err := unlockHistoryKey(profile, state.User.Username, password)
+	if errors.Is(err, store.ErrWrongPassphrase) {
+		return loggedIn{historyLocked: true, password: password, remember: remember}
+	} else if err != nil {
+		return loggedIn{err: fmt.Errorf("could not unlock the history key: %w", err)}
+	}
+	account, err := loadAccount(ctx, state, profile, historyKey)
+	if err != nil {
+		return loggedIn{err: err}
+	}
+
+	result := loggedIn{account: account}
+	if remember != nil {
+		if err := rememberSession(state, remember, historyKey); err != nil {
+			result.warning = fmt.Errorf("could not save the session: %w", err)
+		}
+	}
+	return result
+}
+
+// signUp creates the user with a new identity key, only saved on this
+// device once the server accepted it.
+func signUp(ctx context.Context, state *api.State, profile, username, password string) tea.Msg {
+	path, err := identityPath(profile, username)
+	if err != nil {
+		return signedUp{err: err}
+	}
+	historyKey, err := unlockHistoryKey(profile, username, password)
+	if err != nil {
+		return signedUp{err: fmt.Errorf("could not unlock the history key: %w", err)}
+	}
+	identity, err := e2e.NewIdentity()
+	if err != nil {
+		return signedUp{err: err}
+	}
+	if err := state.HandlerCreateUser(ctx, username, password, identity.PublicKey().Bytes()); err != nil {
+		return signedUp{err: err}
+	}
+	if err := identity.Save(path, historyKey); err != nil {
+		return signedUp{err: fmt.Errorf("could not save the identity key: %w", err)}
+	}
+	return signedUp{}
+}
+
+func historyPath(profile, username string) (string, error) {
+	dir, err := paths.AccountDir(profile, username)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyDir), nil
+}
+
+// unlockHistoryKey unwraps the key of the message history with the
+// password.
+func unlockHistoryKey(profile, username, password string) ([]byte, error) {
+	dir, err := historyPath(profile, username)
+	if err != nil {
+		return nil, err
+	}
+	return store.UnlockKey(dir, password)
+}
+
+// loadAccount loads the local data of the logged in user: identity keys,
+// ratchet sessions, verified keys, undelivered messages and the message
+// history. All but the verified keys are encrypted with historyKey. The
+// first login on a device generates a new key pair, and the public half is
+// published whenever the server does not have it.
+func loadAccount(ctx context.Context, state *api.State, profile string, historyKey []byte) (account, error) {
+	path, err := identityPath(profile, state.User.Username)
+	if err != nil {
+		return account{}, err
+	}
+	identity, err := e2e.LoadOrCreateIdentity(path, historyKey)
+	if err != nil {
+		return account{}, fmt.Errorf("could not load the identity keys: %w", err)
+	}
+	if err := publishIdentity(ctx, state, identity); err != nil {
+		return account{}, err
+	}
+
+	dir, err := paths.AccountDir(profile, state.User.Username)
+	if err != nil {
+		return account{}, err
+	}
+	sessions, err := e2e.OpenSessions(filepath.Join(dir, sessionsFile), historyKey, identity, state.User.Username)
+	if err != nil {
+		return account{}, fmt.Errorf("could not load the encryption sessions: %w", err)
+	}
+
+	trust, err := e2e.OpenTrustStore(filepath.Join(dir, trustFile))
+	if err != nil {
+		return account{}, fmt.Errorf("could not load the verified keys: %w", err)
+	}
+
+	outbox, err := ws.OpenOutbox(filepath.Join(dir, outboxFile), historyKey)
+	if err != nil {
+		return account{}, fmt.Errorf("could not load the undelivered messages: %w", err)
+	}
+
+	history, err := store.OpenHistory(filepath.Join(dir, historyDir), historyKey)
+	if err != nil {
+		return account{}, fmt.Errorf("could not load the message history: %w", err)
+	}
+	return account{sessions: sessions, trust: trust, outbox: outbox, history: history}, nil
+}
+
+// publishIdentity uploads the public key of identity unless the server
+// already has it, e.g. after a failed upload or a login from another
+// device.
+func publishIdentity(ctx context.Context, state *api.State, identity *e2e.Identity) error {
+	publicKey := identity.PublicKey().Bytes()
+	profile, err := state.HandlerCheckUserExists(ctx, state.User.Username)
+	if err == nil && bytes.Equal(profile.PublicKey, publicKey) {
+		return nil
+	} else if err != nil && !errors.Is(err, api.ErrNoPublicKey) {
+		return fmt.Errorf("could not check the published public key: %w", err)
+	}
+	return state.HandlerUploadPublicKey(ctx, publicKey)
+}
+
+func (m *model) setAccount(a account) {
+	m.sessions = a.sessions
+	m.trust = a.trust
+	m.outbox = a.outbox
+	m.history = a.history
+}
@@ -0,0 +1,263 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
+)
+
+const gap = "\n\n"
+
+type WebSocketMessageReceived struct {
+	Message ws.Message
+}
+
+// clientMsg is what a websocket client reports. It is dropped once the
+// client was replaced by switching profiles.
+type clientMsg struct {
+	client *ws.ClientManager
+	msg    tea.Msg
+}
+
+// connect opens the websocket connection once the user is logged in, in
+// the rooms and with the messages stored by the previous session.
+func (m *model) connect() tea.Cmd {
+	m.client = ws.CreateConnection(m.state, m.sessions, m.outbox, m.settings.Heartbeat, m.restoreRooms())
+	m.saveRooms()
+	m.loadHistory()
+	// messages left undelivered by a previous session are retried
+	m.mergeMessages(m.client.PendingMessages())
+	m.refreshMessages()
+	return tea.Batch(
+		m.syncContacts(),
+		m.startPresence(),
+		listenToWebSocketMessages(m.client),
+		listenToNotifications(m.client),
+	)
+}
+
+func (m *model) updateChat(msg tea.Msg) tea.Cmd {
+	// the keys move the selection instead of reaching the textarea
+	if msg, ok := msg.(tea.KeyMsg); ok && m.selecting {
+		return m.updateSelection(msg)
+	}
+
+	var (
+		tiChatCmd tea.Cmd
+		vpChatCmd tea.Cmd
+	)
+
+	atTop := m.viewport.AtTop()
+	draft := m.textarea.Value()
+	m.textarea, tiChatCmd = m.textarea.Update(msg)
+	m.viewport, vpChatCmd = m.viewport.Update(msg)
+	if value := m.textarea.Value(); value != draft && m.editing == uuid.Nil {
+		if strings.TrimSpace(value) == "" {
+			m.client.StopTyping()
+		} else {
+			m.client.Typing()
+		}
+	}
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.viewport.Width = msg.Width - sidebarWidth - 1
+		m.textarea.SetWidth(msg.Width)
+		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap)
+		m.refreshMessages()
+		m.viewport.GotoBottom()
+
+	case tea.KeyMsg:
+		m.err = nil
+		switch msg.Type {
+		case tea.KeyCtrlA:
+			m.flow = addContactView
+			return nil
+
+		case tea.KeyCtrlR:
+			m.flow = roomView
+			return nil
+
+		case tea.KeyCtrlO:
+			m.openContactList()
+			return nil
+
+		case tea.KeyCtrlN:
+			m.cycleRoom(1)
+			return nil
+
+		case tea.KeyCtrlP:
+			m.cycleRoom(-1)
+			return nil
+
+		case tea.KeyCtrlL:
+			m.leaveRoom()
+			return nil
+
+		case tea.KeyCtrlK:
+			if changed := m.changedKeyContacts(); len(changed) > 0 {
+				m.verifyContact = changed[0]
+				m.flow = verifyContactView
+			}
+			return nil
+
+		case tea.KeyCtrlE:
+			if m.editing == uuid.Nil {
+				m.startSelection()
+			}
+			return nil
+
+		case tea.KeyEsc:
+			if m.editing != uuid.Nil {
+				m.cancelEdit()
+			}
+			return nil
+
+		case tea.KeyCtrlG:
+			if len(m.profiles) > 1 {
+				m.switchProfile()
+			}
+			return nil
+
+		case tea.KeyCtrlC:
+			m.client.Close()
+			fmt.Println(m.textarea.Value())
+			return tea.Quit
+
+		case tea.KeyUp, tea.KeyPgUp:
+			// scrolling past the oldest message loads the previous page
+			if atTop {
+				return tea.Batch(tiChatCmd, vpChatCmd, m.loadOlderMessages())
+			}
+
+		case tea.KeyEnter:
+			if m.editing != uuid.Nil {
+				m.submitEdit()
+				return nil
+			}
+			value := strings.TrimSpace(m.textarea.Value())
+			if value == logoutCommand {
+				m.logout()
+				return nil
+			}
+			if args, ok := strings.CutPrefix(value, statusCommand); ok && (args == "" || args[0] == ' ') {
+				if err := m.runStatusCommand(args); err != nil {
+					m.err = err
+					return nil
+				}
+				m.client.StopTyping()
+				m.textarea.Reset()
+				return nil
+			}
+			newMessage := m.client.SetEgress(m.textarea.Value())
+			m.messages = append(m.messages, newMessage)
+			m.client.StopTyping()
+			m.refreshMessages()
+			m.textarea.Reset()
+		}
+	}
+
+	return tea.Batch(tiChatCmd, vpChatCmd)
+}
+
+// updateClient handles what the websocket client reports. Every handled
+// message arms the listener again.
+func (m *model) updateClient(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case WebSocketMessageReceived:
+		delete(m.typing[msg.Message.Room], msg.Message.Sender)
+		m.messages = append(m.messages, msg.Message)
+		m.storeMessages(msg.Message)
+		m.unread = append(m.unread, msg.Message)
+		if !isCurrentRoom(m.client, msg.Message) {
+			m.roomUnread[msg.Message.Room]++
+		}
+		m.refreshMessages()
+		return listenToWebSocketMessages(m.client)
+
+	case ws.ConnectionStatusChanged:
+		m.connStatus = msg.Status
+		m.connErr = msg.Err
+		if msg.Status == ws.Offline {
+			// nobody tells us what changes while we are offline
+			clear(m.typing)
+			clear(m.presence)
+		}
+		if msg.Status == ws.Online {
+			cmds := []tea.Cmd{listenToNotifications(m.client), m.fetchPresence()}
+			for _, room := range m.client.RoomList() {
+				cmds = append(cmds, m.backfillRoom(room.ID()))
+			}
+			return tea.Batch(cmds...)
+		}
+
+	case ws.MessageEdited:
+		m.editMessage(msg.ID, msg.Room, msg.Sender, msg.Content)
+
+	case ws.MessageDeleted:
+		m.deleteMessage(msg.ID, msg.Room, msg.Sender)
+
+	case ws.MessageStatusChanged:
+		m.setMessageStatus(msg.ID, msg.Status)
+		// only the messages the server stored are kept, a failed one must
+		// not come back looking sent
+		if msg.Status == ws.Sent {
+			m.storeSentMessage(msg.ID)
+		}
+		m.refreshMessages()
+
+	case ws.ContactsChanged:
+		return tea.Batch(m.syncContacts(), listenToNotifications(m.client))
+
+	case ws.PresenceChanged:
+		m.setPresence(msg.Presence)
+
+	case ws.TypingChanged:
+		return tea.Batch(m.setTyping(msg), listenToNotifications(m.client))
+
+	case ws.RoomsChanged:
+		// the sidebar reads the rooms from the client
+		m.saveRooms()
+	}
+	return listenToNotifications(m.client)
+}
+
+// markMessagesRead sends the read receipts of the messages received in the
+// current room since the chat was last on screen.
+func (m *model) markMessagesRead() {
+	pending := m.unread[:0]
+	for _, message := range m.unread {
+		if isCurrentRoom(m.client, message) {
+			m.client.MarkRead(message)
+		} else {
+			pending = append(pending, message)
+		}
+	}
+	m.unread = pending
+}
+
+// listenToWebSocketMessages waits for the next message of the client, it
+// returns nothing once the client stopped.
+func listenToWebSocketMessages(client *ws.ClientManager) tea.Cmd {
+	return func() tea.Msg {
+		message, ok := <-client.MessageChannel()
+		if !ok {
+			return nil
+		}
+		return clientMsg{client: client, msg: WebSocketMessageReceived{Message: message}}
+	}
+}
+
+func listenToNotifications(client *ws.ClientManager) tea.Cmd {
+	return func() tea.Msg {
+		notification, ok := <-client.Notifications()
+		if !ok {
+			return nil
+		}
+		return clientMsg{client: client, msg: notification}
+	}
+}
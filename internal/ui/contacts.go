@@ -0,0 +1,244 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var (
+	openConversationKey = key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "open conversation"))
+	verifyKey           = key.NewBinding(key.WithKeys("v"), key.WithHelp("v", "verify key"))
+	removeContactKey    = key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "remove"))
+	backKey             = key.NewBinding(key.WithKeys("ctrl+b"), key.WithHelp("ctrl+b", "back"))
+)
+
+// addContact registers the profile as a contact, recording its key in the
+// trust store and making it a recipient of our messages.
+func (m *model) addContact(profile api.UserProfile) error {
+	key, err := e2e.ParsePublicKey(profile.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for %s", profile.Username)
+	}
+	if _, err := m.trust.Observe(profile.Username, profile.PublicKey); err != nil {
+		return err
+	}
+	m.client.AddPeer(profile.Username, profile.ID, key)
+	m.contacts[profile.Username] = profile
+	return nil
+}
+
+func (m *model) removeContact(name string) {
+	delete(m.contacts, name)
+	delete(m.presence, name)
+	m.client.RemovePeer(name)
+}
+
+type contactAdded struct {
+	profile api.UserProfile
+	err     error
+}
+
+type contactRemoved struct {
+	name string
+	err  error
+}
+
+type contactsFetched struct {
+	profiles []api.UserProfile
+	err      error
+}
+
+func (m *model) contactAdded(result contactAdded) {
+	if result.err != nil {
+		m.loginError = requestError(result.err)
+		return
+	}
+	if err := m.addContact(result.profile); err != nil {
+		m.loginError = err.Error()
+		return
+	}
+	m.newContact.Reset()
+	// give the user the chance to verify the key right away
+	m.verifyContact = result.profile.Username
+	m.flow = verifyContactView
+}
+
+func (m *model) contactRemoved(result contactRemoved) {
+	if result.err != nil {
+		m.loginError = requestError(result.err)
+		return
+	}
+	m.removeContact(result.name)
+	m.refreshContactList()
+}
+
+// syncContacts fetches the contacts stored on the server, which are shared
+// by every device of the account.
+func (m *model) syncContacts() tea.Cmd {
+	state := m.state
+	return m.startRequest("Syncing the contacts", false, func(ctx context.Context) tea.Msg {
+		profiles, err := state.HandlerListContacts(ctx)
+		return contactsFetched{profiles: profiles, err: err}
+	})
+}
+
+// contactsFetched replaces the local contacts with the list stored on the
+// server.
+func (m *model) contactsFetched(result contactsFetched) {
+	if result.err != nil {
+		m.err = errors.New(requestError(result.err))
+		return
+	}
+
+	listed := make(map[string]struct{}, len(result.profiles))
+	errs := []error{}
+	for _, profile := range result.profiles {
+		listed[profile.Username] = struct{}{}
+		if err := m.addContact(profile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name := range m.contacts {
+		if _, ok := listed[name]; !ok {
+			m.removeContact(name)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		m.err = err
+	}
+	m.refreshContactList()
+}
+
+func (m *model) sortedContacts() []string {
+	names := make([]string, 0, len(m.contacts))
+	for name := range m.contacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// contactItem is a line of the contact list, it keeps the name apart from
+// the decorated label.
+type contactItem struct {
+	name  string
+	label string
+}
+
+func (i contactItem) FilterValue() string { return i.name }
+
+// contactItems lists the contacts with their presence and the status of
+// their key.
+func (m *model) contactItems() []list.Item {
+	items := []list.Item{}
+	for _, name := range m.sortedContacts() {
+		label := m.presenceDot(name) + " " + name
+		switch m.trust.Status(name) {
+		case e2e.Verified:
+			label += " ✔"
+		case e2e.KeyChanged:
+			label += " ⚠"
+		}
+		if presence, ok := m.presence[name]; ok {
+			label += " (" + presenceString(presence) + ")"
+		}
+		items = append(items, contactItem{name: name, label: label})
+	}
+	return items
+}
+
+// refreshContactList rebuilds the open contact list after the contacts or
+// their presence changed, the selection stays on the same contact when it
+// is still listed.
+func (m *model) refreshContactList() {
+	if m.flow != contactsView {
+		return
+	}
+	selected, _ := m.contactList.SelectedItem().(contactItem)
+	items := m.contactItems()
+	m.contactList.SetItems(items)
+	for i, listItem := range items {
+		if listItem.(contactItem).name == selected.name {
+			m.contactList.Select(i)
+			return
+		}
+	}
+	if m.contactList.Index() >= len(items) {
+		m.contactList.Select(max(len(items)-1, 0))
+	}
+}
+
+func (m *model) openContactList() {
+	l := list.New(m.contactItems(), itemDelegate{}, m.viewport.Width, 14)
+	l.Title = "Contacts"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	l.KeyMap.Quit.SetEnabled(false)
+	l.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{openConversationKey, verifyKey, removeContactKey, backKey}
+	}
+
+	m.contactList = l
+	m.flow = contactsView
+}
+
+func (m *model) updateContactList(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		m.loginError = ""
+		switch {
+		case key.Matches(msg, backKey):
+			m.flow = chatView
+			return nil
+
+		case key.Matches(msg, openConversationKey, verifyKey, removeContactKey):
+			selected, ok := m.contactList.SelectedItem().(contactItem)
+			if !ok {
+				return nil
+			}
+			name := selected.name
+			switch {
+			case key.Matches(msg, verifyKey):
+				m.verifyContact = name
+				m.flow = verifyContactView
+				return nil
+			case key.Matches(msg, removeContactKey):
+				state := m.state
+				return m.startRequest("Removing "+name, true, func(ctx context.Context) tea.Msg {
+					return contactRemoved{name: name, err: state.HandlerRemoveContact(ctx, name)}
+				})
+			}
+
+			m.flow = chatView
+			room, err := m.client.OpenDirectRoom(name, m.contacts[name].ID)
+			if err != nil {
+				m.err = err
+				return nil
+			}
+			m.switchRoom(room.ID())
+			return m.backfillRoom(room.ID())
+		}
+	}
+
+	var cmd tea.Cmd
+	m.contactList, cmd = m.contactList.Update(msg)
+	return cmd
+}
+
+func (m model) contactsViewString() string {
+	view := "\n" + m.contactList.View() + "\n" + m.requestStatusString()
+	if m.loginError != "" {
+		view += "\n" + m.loginError + "\n"
+	}
+	return view
+}
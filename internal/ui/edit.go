@@ -0,0 +1,195 @@
+package ui
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
+)
+
+var (
+	previousMessageKey = key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "previous"))
+	nextMessageKey     = key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "next"))
+	editMessageKey     = key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit"))
+	deleteMessageKey   = key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "delete"))
+	cancelKey          = key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "cancel"))
+
+	hintStyle           = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	selectedMarkerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("170"))
+	tombstoneStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+
+	errNothingToSelect = errors.New("there is no message of yours to edit in this room")
+	errEmptyEdit       = errors.New("the message cannot be empty, delete it instead")
+)
+
+// ownMessages returns our messages of the current room that can still be
+// edited, in the order they are shown.
+func (m *model) ownMessages() []ws.Message {
+	messages := []ws.Message{}
+	for _, message := range m.messages {
+		if isCurrentRoom(m.client, message) && message.Sender == m.state.User.Username && !message.Deleted &&
+			message.Status != ws.Pending && message.Status != ws.Failed {
+			messages = append(messages, message)
+		}
+	}
+	slices.SortStableFunc(messages, func(a, b ws.Message) int {
+		return a.Date.Compare(b.Date)
+	})
+	return messages
+}
+
+// startSelection selects our most recent message of the current room.
+func (m *model) startSelection() {
+	messages := m.ownMessages()
+	if len(messages) == 0 {
+		m.err = errNothingToSelect
+		return
+	}
+	m.selecting = true
+	m.selected = messages[len(messages)-1].ID
+	m.renderMessages()
+}
+
+func (m *model) stopSelection() {
+	m.selecting = false
+	m.confirmDelete = false
+	m.selected = uuid.Nil
+	m.refreshMessages()
+}
+
+// updateSelection moves the selection among our messages and edits or
+// deletes the selected one. Deleting asks for the key a second time.
+func (m *model) updateSelection(msg tea.KeyMsg) tea.Cmd {
+	m.err = nil
+	confirmed := m.confirmDelete
+	m.confirmDelete = false
+
+	messages := m.ownMessages()
+	i := slices.IndexFunc(messages, func(message ws.Message) bool { return message.ID == m.selected })
+	if i < 0 {
+		m.stopSelection()
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, cancelKey):
+		// esc on the confirmation only gives up the deletion
+		if !confirmed {
+			m.stopSelection()
+		}
+
+	case key.Matches(msg, previousMessageKey):
+		m.selected = messages[max(i-1, 0)].ID
+		m.renderMessages()
+
+	case key.Matches(msg, nextMessageKey):
+		m.selected = messages[min(i+1, len(messages)-1)].ID
+		m.renderMessages()
+
+	case key.Matches(msg, editMessageKey):
+		m.editing = messages[i].ID
+		m.textarea.SetValue(messages[i].Content)
+		m.textarea.CursorEnd()
+		m.stopSelection()
+
+	case key.Matches(msg, deleteMessageKey):
+		if !confirmed {
+			m.confirmDelete = true
+			return nil
+		}
+		if err := m.client.DeleteMessage(messages[i]); err != nil {
+			m.err = err
+			return nil
+		}
+		m.deleteMessage(messages[i].ID, messages[i].Room, messages[i].Sender)
+		m.stopSelection()
+	}
+	return nil
+}
+
+// submitEdit sends the content of the textarea as the new content of the
+// message being edited.
+func (m *model) submitEdit() {
+	content := m.textarea.Value()
+	if strings.TrimSpace(content) == "" {
+		m.err = errEmptyEdit
+		return
+	}
+	i := slices.IndexFunc(m.messages, func(message ws.Message) bool { return message.ID == m.editing })
+	if i < 0 {
+		m.cancelEdit()
+		return
+	}
+
+	message := m.messages[i]
+	if content != message.Content {
+		if err := m.client.EditMessage(message, content); err != nil {
+			m.err = err
+			return
+		}
+		m.editMessage(message.ID, message.Room, message.Sender, content)
+	}
+	m.cancelEdit()
+}
+
+func (m *model) cancelEdit() {
+	m.editing = uuid.Nil
+	m.textarea.Reset()
+}
+
+// editMessage applies an edit from the sender of a message, on screen and
+// in the history.
+func (m *model) editMessage(id, room uuid.UUID, sender, content string) {
+	m.changeMessage(id, room, sender, func(message *ws.Message) {
+		message.Content = content
+		message.Edited = true
+	})
+	if err := m.history.Edit(room, id, sender, content); err != nil {
+		m.err = err
+	}
+}
+
+// deleteMessage leaves a tombstone in place of a message deleted by its
+// sender, on screen and in the history.
+func (m *model) deleteMessage(id, room uuid.UUID, sender string) {
+	m.changeMessage(id, room, sender, func(message *ws.Message) {
+		message.Content = ""
+		message.Edited = false
+		message.Deleted = true
+	})
+	if err := m.history.Delete(room, id, sender); err != nil {
+		m.err = err
+	}
+}
+
+// changeMessage applies change to the message id of room when sender sent
+// it. The sender of a change was authenticated by its payload.
+func (m *model) changeMessage(id, room uuid.UUID, sender string, change func(*ws.Message)) {
+	i := slices.IndexFunc(m.messages, func(message ws.Message) bool {
+		return message.ID == id && message.Room == room
+	})
+	if i < 0 || m.messages[i].Sender != sender || m.messages[i].Deleted {
+		return
+	}
+	change(&m.messages[i])
+	m.renderMessages()
+}
+
+// chatStatusString is the line above the textarea: the keys while a
+// message is selected or edited, otherwise who is typing.
+func (m model) chatStatusString() string {
+	switch {
+	case m.confirmDelete:
+		return hintStyle.Render("x: delete the message for everyone • esc: cancel")
+	case m.selecting:
+		return hintStyle.Render("↑/↓: select • e: edit • x: delete • esc: back")
+	case m.editing != uuid.Nil:
+		return hintStyle.Render("Editing a message • enter: save • esc: cancel")
+	}
+	return m.typingStatusString()
+}
@@ -0,0 +1,89 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	warningStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("9"))
+	verifiedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	blockStyle    = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(0, 1)
+)
+
+const blockSide = 16
+
+// renderSafetyBlock draws the 256 bits of the digest as a square grid, two
+// rows of bits per line of text.
+func renderSafetyBlock(digest [32]byte) string {
+	bit := func(row, col int) bool {
+		i := row*blockSide + col
+		return digest[i/8]&(1<<(7-i%8)) != 0
+	}
+
+	var b strings.Builder
+	for row := 0; row < blockSide; row += 2 {
+		for col := range blockSide {
+			top, bottom := bit(row, col), bit(row+1, col)
+			switch {
+			case top && bottom:
+				b.WriteString("██")
+			case top:
+				b.WriteString("▀▀")
+			case bottom:
+				b.WriteString("▄▄")
+			default:
+				b.WriteString("  ")
+			}
+		}
+		if row < blockSide-2 {
+			b.WriteRune('\n')
+		}
+	}
+	return blockStyle.Render(b.String())
+}
+
+func (m model) verifyContactViewString() string {
+	var b strings.Builder
+	name := m.verifyContact
+	code := e2e.NewSafetyCode(
+		m.state.User.Username, m.sessions.Identity().PublicKey().Bytes(),
+		name, m.contacts[name].PublicKey,
+	)
+
+	b.WriteString("Safety number with " + name + "\n\n")
+	for i := 0; i < len(code.Digits); i += 4 {
+		b.WriteString("  " + strings.Join(code.Digits[i:i+4], " ") + "\n")
+	}
+	b.WriteRune('\n')
+	b.WriteString(renderSafetyBlock(code.Digest))
+	b.WriteString("\n\n")
+
+	switch m.trust.Status(name) {
+	case e2e.Verified:
+		b.WriteString(verifiedStyle.Render("✔ Verified"))
+	case e2e.KeyChanged:
+		b.WriteString(warningStyle.Render("⚠ The key of " + name + " changed since you verified it!"))
+	default:
+		b.WriteString("Not verified")
+	}
+	b.WriteString("\n\nCompare the numbers or the block with " + name + " in person or over a trusted channel.\n")
+	b.WriteString("enter: mark as verified • ctrl+b: back\n")
+
+	if m.loginError != "" {
+		b.WriteString(m.loginError)
+		b.WriteRune('\n')
+	}
+
+	return b.String()
+}
+
+func (m model) keyWarningString() string {
+	changed := m.changedKeyContacts()
+	if len(changed) == 0 {
+		return ""
+	}
+	return warningStyle.Render("⚠ The identity key of "+strings.Join(changed, ", ")+" changed since it was verified. Press ctrl+k to review.") + "\n"
+}
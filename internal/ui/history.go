@@ -0,0 +1,181 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+)
+
+// historyPageSize is the number of stored messages loaded at once for a room.
+const historyPageSize = 50
+
+// restoreRooms returns the rooms joined in a previous session.
+func (m *model) restoreRooms() []ws.Room {
+	states, err := m.history.Rooms()
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	rooms := make([]ws.Room, 0, len(states))
+	for _, state := range states {
+		rooms = append(rooms, ws.RestoreRoom(state))
+	}
+	return rooms
+}
+
+func (m *model) saveRooms() {
+	rooms := m.client.RoomList()
+	states := make([]ws.RoomState, 0, len(rooms))
+	for _, room := range rooms {
+		states = append(states, room.State())
+	}
+	if err := m.history.SaveRooms(states); err != nil {
+		m.err = err
+	}
+}
+
+// loadHistory loads the most recent messages of every joined room.
+func (m *model) loadHistory() {
+	for _, room := range m.client.RoomList() {
+		page, err := m.history.Page(room.ID(), 0, historyPageSize)
+		if err != nil {
+			m.err = err
+			continue
+		}
+		m.historyLoaded[room.ID()] = len(page)
+		m.mergeMessages(page)
+	}
+}
+
+// historyFetched is a page of the server history of room. A backfill page
+// holds the most recent messages.
+type historyFetched struct {
+	room     uuid.UUID
+	backfill bool
+	page     api.HistoryPage
+	err      error
+}
+
+// loadOlderMessages prepends the previous page of the current room, from
+// the local history first and from the server once it is exhausted.
+func (m *model) loadOlderMessages() tea.Cmd {
+	room := m.client.Current().ID()
+	page, err := m.history.Page(room, m.historyLoaded[room], historyPageSize)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	if len(page) > 0 {
+		m.historyLoaded[room] += len(page)
+		m.prependMessages(page)
+		return nil
+	}
+
+	if m.historyDone[room] || m.historyLoading[room] {
+		return nil
+	}
+	m.historyLoading[room] = true
+	return m.fetchHistory(room, m.historyCursor[room], false)
+}
+
+// backfillRoom fetches the most recent messages stored on the server for
+// room, the ones sent while we were not connected.
+func (m *model) backfillRoom(room uuid.UUID) tea.Cmd {
+	return m.fetchHistory(room, "", true)
+}
+
+func (m *model) fetchHistory(room uuid.UUID, cursor string, backfill bool) tea.Cmd {
+	state := m.state
+	return m.startRequest("Loading the history", false, func(ctx context.Context) tea.Msg {
+		page, err := state.HandlerRoomHistory(ctx, room, cursor, historyPageSize)
+		return historyFetched{room: room, backfill: backfill, page: page, err: err}
+	})
+}
+
+// historyFetched adds the unknown messages of a server history page.
+func (m *model) historyFetched(result historyFetched) {
+	if !result.backfill {
+		delete(m.historyLoading, result.room)
+	}
+	if result.err != nil {
+		m.err = errors.New(requestError(result.err))
+		return
+	}
+
+	// the scrollback continues where it was when it already started
+	if _, started := m.historyCursor[result.room]; !result.backfill || !started {
+		m.historyCursor[result.room] = result.page.NextCursor
+		m.historyDone[result.room] = result.page.NextCursor == ""
+	}
+
+	messages := m.client.DecryptHistory(result.page.Messages, m.hasMessage)
+	slices.Reverse(messages)
+	m.storeMessages(messages...)
+	if result.backfill {
+		m.messages = append(m.messages, messages...)
+		m.refreshMessages()
+	} else {
+		m.prependMessages(messages)
+	}
+}
+
+// prependMessages adds older messages before the known ones and keeps the
+// viewport on the messages that were on screen.
+func (m *model) prependMessages(messages []ws.Message) {
+	messages = slices.DeleteFunc(messages, func(message ws.Message) bool {
+		return m.hasMessage(message.ID)
+	})
+	if len(messages) == 0 {
+		return
+	}
+	lines := m.viewport.TotalLineCount()
+	m.messages = append(messages, m.messages...)
+	m.renderMessages()
+	m.viewport.SetYOffset(m.viewport.TotalLineCount() - lines)
+}
+
+// storeMessages adds messages to the history of their rooms.
+func (m *model) storeMessages(messages ...ws.Message) {
+	if err := m.history.Append(messages...); err != nil {
+		m.err = err
+		return
+	}
+	// the messages are on screen already, the next page starts before them
+	for _, message := range messages {
+		m.historyLoaded[message.Room]++
+	}
+}
+
+// storeSentMessage stores one of our messages once the server acked it.
+func (m *model) storeSentMessage(id uuid.UUID) {
+	i := slices.IndexFunc(m.messages, func(message ws.Message) bool { return message.ID == id })
+	if i >= 0 {
+		m.storeMessages(m.messages[i])
+	}
+}
+
+// mergeMessages adds the messages that are not known yet. A known message
+// is replaced, so the delivery status of the newer copy is kept.
+func (m *model) mergeMessages(messages []ws.Message) {
+	for _, message := range messages {
+		i := slices.IndexFunc(m.messages, func(known ws.Message) bool {
+			return known.ID == message.ID
+		})
+		if i >= 0 {
+			m.messages[i] = message
+		} else {
+			m.messages = append(m.messages, message)
+		}
+	}
+}
+
+func (m *model) hasMessage(id uuid.UUID) bool {
+	return slices.ContainsFunc(m.messages, func(message ws.Message) bool {
+		return message.ID == id
+	})
+}
@@ -27,12 +27,17 @@ func (d itemDelegate) Height() int                             { return 1 }
 func (d itemDelegate) Spacing() int                            { return 0 }
 func (d itemDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
 func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
-	i, ok := listItem.(item)
-	if !ok {
+	var label string
+	switch i := listItem.(type) {
+	case item:
+		label = string(i)
+	case contactItem:
+		label = i.label
+	default:
 		return
 	}
 
-	str := fmt.Sprintf("%d. %s", index+1, i)
+	str := fmt.Sprintf("%d. %s", index+1, label)
 
 	fn := itemStyle.Render
 	if index == m.Index() {
@@ -0,0 +1,153 @@
+package ui
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
+)
+
+var (
+	pendingStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	readStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+	failedStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	timeStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	separatorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+)
+
+// groupInterval is the longest pause between two messages of the same
+// sender that are still shown as a group.
+const groupInterval = 5 * time.Minute
+
+// refreshMessages renders the messages of the current room into the
+// viewport and scrolls to the bottom.
+func (m *model) refreshMessages() {
+	m.renderMessages()
+	m.viewport.GotoBottom()
+}
+
+func (m *model) renderMessages() {
+	messages := []ws.Message{}
+	for _, message := range m.messages {
+		if isCurrentRoom(m.client, message) {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		m.viewport.SetContent(welcomeMessage)
+		return
+	}
+	slices.SortStableFunc(messages, func(a, b ws.Message) int {
+		return a.Date.Compare(b.Date)
+	})
+
+	now := time.Now().In(m.settings.Location)
+	wrap := lipgloss.NewStyle().Width(m.viewport.Width)
+	lines := []string{}
+	selected := -1
+	for i, message := range messages {
+		var previous *ws.Message
+		if i > 0 {
+			previous = &messages[i-1]
+		}
+		date := message.Date.In(m.settings.Location)
+		if !message.Date.IsZero() && (previous == nil || !sameDay(previous.Date.In(m.settings.Location), date)) {
+			lines = append(lines, m.daySeparator(date, now))
+		}
+		grouped := previous != nil && previous.Sender == message.Sender &&
+			message.Date.Sub(previous.Date) < groupInterval && sameDay(previous.Date.In(m.settings.Location), date)
+		line := m.renderMessage(message, grouped)
+		if m.selecting {
+			if message.ID == m.selected {
+				line = selectedMarkerStyle.Render("▶ ") + line
+				selected = len(lines)
+			} else {
+				line = "  " + line
+			}
+		}
+		lines = append(lines, line)
+	}
+	// Wrap content before setting it.
+	m.viewport.SetContent(wrap.Render(strings.Join(lines, "\n")))
+
+	// keep the selected message on screen, it starts after the wrapped
+	// lines before it
+	if selected >= 0 {
+		selectedLine := 0
+		if selected > 0 {
+			selectedLine = lipgloss.Height(wrap.Render(strings.Join(lines[:selected], "\n")))
+		}
+		if selectedLine < m.viewport.YOffset {
+			m.viewport.SetYOffset(selectedLine)
+		} else if bottom := m.viewport.YOffset + m.viewport.Height; selectedLine >= bottom {
+			m.viewport.SetYOffset(selectedLine - m.viewport.Height + 1)
+		}
+	}
+}
+
+// renderMessage renders a line of the chat. A grouped message follows
+// another one of the same sender and only shows its content.
+func (m *model) renderMessage(message ws.Message, grouped bool) string {
+	sender := message.Sender
+	if sender == m.state.User.Username {
+		sender = "You"
+	}
+	prefix := m.senderStyle.Render(sender) + ": "
+	if !message.Date.IsZero() {
+		prefix = timeStyle.Render(message.Date.In(m.settings.Location).Format(m.settings.TimeFormat)) + " " + prefix
+	}
+	if grouped {
+		prefix = strings.Repeat(" ", lipgloss.Width(prefix))
+	}
+	if message.Deleted {
+		return prefix + tombstoneStyle.Render("message deleted")
+	}
+	line := prefix + message.Content
+	if message.Edited {
+		line += " " + timeStyle.Render("(edited)")
+	}
+
+	switch message.Status {
+	case ws.Pending:
+		line += " " + pendingStyle.Render("◷")
+	case ws.Sent:
+		line += " " + pendingStyle.Render("✓")
+	case ws.Delivered:
+		line += " " + pendingStyle.Render("✓✓")
+	case ws.Read:
+		line += " " + readStyle.Render("✓✓")
+	case ws.Failed:
+		line += " " + failedStyle.Render("✗ not sent")
+	}
+	return line
+}
+
+// daySeparator renders the divider shown before the first message of a day.
+func (m *model) daySeparator(date, now time.Time) string {
+	label := date.Format("Monday, 2 January 2006")
+	switch {
+	case sameDay(date, now):
+		label = "Today"
+	case sameDay(date, now.AddDate(0, 0, -1)):
+		label = "Yesterday"
+	}
+	return separatorStyle.Render("── " + label + " ──")
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+func (m *model) setMessageStatus(id uuid.UUID, status ws.DeliveryStatus) {
+	for i := range m.messages {
+		if m.messages[i].ID == id && m.messages[i].Status.Advances(status) {
+			m.messages[i].Status = status
+			return
+		}
+	}
+}
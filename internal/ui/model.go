@@ -1,15 +1,47 @@
 package ui
 
 import (
+	"time"
+
 	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/CTSDM/motbwa-tui/internal/keyring"
+	"github.com/CTSDM/motbwa-tui/internal/store"
 	"github.com/CTSDM/motbwa-tui/internal/ws"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
 )
 
+const welcomeMessage = `Welcome to the chat room!
+Type a message and press Enter to send.`
+
+// Settings are the preferences of the user.
+type Settings struct {
+	// TimeFormat is the layout, as in time.Format, of the message times.
+	TimeFormat string
+	// Location is the time zone the message times are shown in.
+	Location *time.Location
+	Theme    Theme
+	// AwayAfter is the idle time after which the user shows as away, zero
+	// disables it.
+	AwayAfter time.Duration
+	// Heartbeat is the keepalive of the websocket connection.
+	Heartbeat ws.Heartbeat
+}
+
+var DefaultSettings = Settings{
+	TimeFormat: "15:04",
+	Location:   time.Local,
+	Theme:      themes["default"],
+	AwayAfter:  5 * time.Minute,
+	Heartbeat:  ws.DefaultHeartbeat,
+}
+
 type flowState int
 
 const (
@@ -18,11 +50,29 @@ const (
 	loginView
 	chatView
 	addContactView
+	verifyContactView
+	roomView
+	contactsView
+	profileView
+	unlockView
+	rekeyView
 )
 
 type model struct {
-	flow  flowState
-	state api.State
+	flow     flowState
+	state    *api.State
+	profile  Profile
+	settings Settings
+	sessions *e2e.Sessions
+
+	// api calls in flight
+	requests    map[int]request
+	lastRequest int
+	spinner     spinner.Model
+
+	// profileView
+	profiles    []Profile
+	profileList list.Model
 
 	// initView
 	initList    list.Model
@@ -30,21 +80,67 @@ type model struct {
 	assignation map[string]flowState
 
 	// contacts
-	newContact textinput.Model
-	contacts   map[string]struct{}
+	newContact    textinput.Model
+	contacts      map[string]api.UserProfile
+	contactList   list.Model
+	trust         *e2e.TrustStore
+	verifyContact string
 
 	// login and create user components
 	focusIndex  int
 	credentials []textinput.Model
 	loginError  string
 
+	// saved session, secretService is known once probed
+	remember         bool
+	secretService    bool
+	probed           bool
+	resumeAfterProbe bool
+	unlock           textinput.Model
+
+	// rekeyView asks for the previous password to unlock the history, it
+	// is wrapped again with rekeyPassword
+	rekey         textinput.Model
+	rekeyPassword string
+	rekeyRemember keyring.Store
+
 	// chat components
-	client      *ws.ClientManager
-	messages    []ws.Message
-	textarea    textarea.Model
-	viewport    viewport.Model
-	senderStyle lipgloss.Style
-	err         error
+	client  *ws.ClientManager
+	outbox  *ws.Outbox
+	history *store.History
+	// historyLoaded counts the stored messages of each room on screen
+	historyLoaded map[uuid.UUID]int
+	// historyCursor is where the server history of each room continues,
+	// historyDone marks the rooms whose server history was fully loaded
+	historyCursor map[uuid.UUID]string
+	historyDone   map[uuid.UUID]bool
+	// historyLoading marks the rooms whose previous page is being fetched
+	historyLoading map[uuid.UUID]bool
+	connStatus     ws.ConnectionStatus
+	connErr        error
+	messages       []ws.Message
+	unread         []ws.Message
+	roomUnread     map[uuid.UUID]int
+	// typing holds when the indicator of each user typing expires, by room
+	typing map[uuid.UUID]map[string]time.Time
+	// presence of the contacts by username, and the one of the user, set
+	// by hand or away after idling
+	presence     map[string]api.Presence
+	status       api.Presence
+	statusManual bool
+	idleAway     bool
+	lastActivity time.Time
+	// selected is the message picked with selecting on, editing the one
+	// whose new content is in the textarea
+	selecting     bool
+	selected      uuid.UUID
+	confirmDelete bool
+	editing       uuid.UUID
+	newRoom       textinput.Model
+	textarea      textarea.Model
+	viewport      viewport.Model
+	senderStyle   lipgloss.Style
+	err           error
 }
 
 func initializeChatView() (textarea.Model, viewport.Model) {
@@ -64,8 +160,7 @@ func initializeChatView() (textarea.Model, viewport.Model) {
 	ta.ShowLineNumbers = false
 
 	vp := viewport.New(30, 5)
-	vp.SetContent(`Welcome to the chat room!
-Type a message and press Enter to send.`)
+	vp.SetContent(welcomeMessage)
 
 	ta.KeyMap.InsertNewline.SetEnabled(false)
 
@@ -75,12 +170,25 @@ Type a message and press Enter to send.`)
 func initializeAddContactView() textinput.Model {
 	t := textinput.New()
 	t.Width = 32
-	t.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	t.Cursor.Style = lipgloss.NewStyle().Foreground(theme.Accent)
 	t.Placeholder = "Username"
 	t.CharLimit = 32
 	t.Focus()
-	t.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
-	t.TextStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	t.PromptStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.TextStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+
+	return t
+}
+
+func initializeRoomView() textinput.Model {
+	t := textinput.New()
+	t.Width = 40
+	t.Cursor.Style = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.Placeholder = "Room name or ID"
+	t.CharLimit = 40
+	t.Focus()
+	t.PromptStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.TextStyle = lipgloss.NewStyle().Foreground(theme.Accent)
 
 	return t
 }
@@ -105,24 +213,29 @@ func initializeInitView(items []string) list.Model {
 }
 
 func initializeCredentialsView() []textinput.Model {
-	inputs := make([]textinput.Model, 2)
+	inputs := make([]textinput.Model, 3)
 
 	for i := range inputs {
 		t := textinput.New()
 		t.Width = 32
-		t.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+		t.Cursor.Style = lipgloss.NewStyle().Foreground(theme.Accent)
 		switch i {
 		case 0:
 			t.Placeholder = "Username"
 			t.CharLimit = 32
 			t.Focus()
-			t.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
-			t.TextStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+			t.PromptStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+			t.TextStyle = lipgloss.NewStyle().Foreground(theme.Accent)
 
 		case 1:
 			t.Placeholder = "Password"
 			t.EchoMode = textinput.EchoPassword
 			t.EchoCharacter = '*'
+
+		case 2:
+			t.Placeholder = "Passphrase for the saved session"
+			t.EchoMode = textinput.EchoPassword
+			t.EchoCharacter = '*'
 		}
 
 		inputs[i] = t
@@ -131,8 +244,23 @@ func initializeCredentialsView() []textinput.Model {
 	return inputs
 }
 
-func InitialModel(state api.State) model {
+// InitialModel starts with the profile called start, or with the profile
+// picker when there is no such profile and more than one to pick from.
+func InitialModel(profiles []Profile, start string, settings Settings) model {
+	m := newModel(profiles, settings)
+	for _, profile := range profiles {
+		if profile.Name == start || len(profiles) == 1 {
+			m.selectProfile(profile)
+		}
+	}
+	return m
+}
+
+func newModel(profiles []Profile, settings Settings) model {
 	items := []string{"Login", "Sign up"}
+	if len(profiles) > 1 {
+		items = append(items, switchProfileItem)
+	}
 	assignation := map[string]flowState{}
 	for i := range items {
 		caseLogin := "Login"
@@ -142,32 +270,54 @@ func InitialModel(state api.State) model {
 			assignation[caseLogin] = loginView
 		case caseSignUp:
 			assignation[caseSignUp] = signUpView
+		case switchProfileItem:
+			assignation[switchProfileItem] = profileView
 		}
 	}
 
+	applyTheme(settings.Theme)
 	taChat, vpChat := initializeChatView()
 	tiCredentials := initializeCredentialsView()
 	tiContact := initializeAddContactView()
+	tiRoom := initializeRoomView()
 	initList := initializeInitView(items)
 
 	return model{
+		// profileView
+		profiles:    profiles,
+		profileList: initializeProfileView(profiles),
+
 		// initView
 		initList: initList,
-		state:    state,
-		flow:     initView,
+		settings: settings,
+		flow:     profileView,
+		requests: make(map[int]request),
+		spinner:  spinner.New(spinner.WithSpinner(spinner.Dot), spinner.WithStyle(lipgloss.NewStyle().Foreground(theme.Accent))),
 		// login and create user
 		credentials: tiCredentials,
 		assignation: assignation,
 
+		// saved session
+		unlock: initializeUnlockView(),
+		rekey:  initializeRekeyView(),
+
 		// new contact
 		newContact: tiContact,
-		contacts:   make(map[string]struct{}),
+		contacts:   make(map[string]api.UserProfile),
 
 		//chat
-		textarea:    taChat,
-		viewport:    vpChat,
-		senderStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("5")),
-		err:         nil,
-		messages:    make([]ws.Message, 0),
+		textarea:       taChat,
+		viewport:       vpChat,
+		senderStyle:    lipgloss.NewStyle().Foreground(theme.Sender),
+		err:            nil,
+		messages:       make([]ws.Message, 0),
+		roomUnread:     make(map[uuid.UUID]int),
+		typing:         make(map[uuid.UUID]map[string]time.Time),
+		presence:       make(map[string]api.Presence),
+		historyLoaded:  make(map[uuid.UUID]int),
+		historyCursor:  make(map[uuid.UUID]string),
+		historyDone:    make(map[uuid.UUID]bool),
+		historyLoading: make(map[uuid.UUID]bool),
+		newRoom:        tiRoom,
 	}
 }
@@ -0,0 +1,166 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// statusCommand sets the presence by hand: /status online|away [message]
+	statusCommand = "/status"
+	// idleCheckInterval is how often the idle time is compared with the
+	// AwayAfter setting.
+	idleCheckInterval = 15 * time.Second
+)
+
+var (
+	errStatusUsage = errors.New("usage: " + statusCommand + " online|away [message]")
+
+	presenceStyles = map[api.PresenceStatus]lipgloss.Style{
+		api.PresenceOnline:  lipgloss.NewStyle().Foreground(lipgloss.Color("10")),
+		api.PresenceAway:    lipgloss.NewStyle().Foreground(lipgloss.Color("11")),
+		api.PresenceOffline: lipgloss.NewStyle().Foreground(lipgloss.Color("8")),
+	}
+	unknownPresenceStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+)
+
+// idleCheck asks to check whether the user went idle. It is dropped once
+// the client was replaced.
+type idleCheck struct {
+	client *ws.ClientManager
+}
+
+type presenceFetched struct {
+	presence []api.Presence
+	err      error
+}
+
+// startPresence announces the user online, away comes after the idle time
+// unless the user sets the status by hand.
+func (m *model) startPresence() tea.Cmd {
+	m.lastActivity = time.Now()
+	m.status = api.Presence{Username: m.state.User.Username, Status: api.PresenceOnline}
+	m.client.SetPresence(m.status.Status, m.status.Message)
+	return m.checkIdle()
+}
+
+func (m *model) checkIdle() tea.Cmd {
+	if m.settings.AwayAfter == 0 {
+		return nil
+	}
+	client := m.client
+	return tea.Tick(idleCheckInterval, func(time.Time) tea.Msg { return idleCheck{client: client} })
+}
+
+// updateIdle shows the user away once idle for AwayAfter.
+func (m *model) updateIdle() {
+	if m.statusManual || m.status.Status != api.PresenceOnline {
+		return
+	}
+	if time.Since(m.lastActivity) >= m.settings.AwayAfter {
+		m.idleAway = true
+		m.status.Status = api.PresenceAway
+		m.client.SetPresence(m.status.Status, m.status.Message)
+	}
+}
+
+// markActive records a key press, bringing the user back online after an
+// automatic away.
+func (m *model) markActive() {
+	m.lastActivity = time.Now()
+	if !m.idleAway || m.client == nil {
+		return
+	}
+	m.idleAway = false
+	m.status.Status = api.PresenceOnline
+	m.client.SetPresence(m.status.Status, m.status.Message)
+}
+
+// runStatusCommand applies the arguments of the status command. A status
+// set to away stays until it is set back to online.
+func (m *model) runStatusCommand(args string) error {
+	word, message, _ := strings.Cut(strings.TrimSpace(args), " ")
+	status := api.PresenceStatus(word)
+	switch status {
+	case api.PresenceOnline:
+		m.statusManual = false
+	case api.PresenceAway:
+		m.statusManual = true
+	default:
+		return errStatusUsage
+	}
+	m.idleAway = false
+	m.status.Status = status
+	m.status.Message = strings.TrimSpace(message)
+	m.client.SetPresence(m.status.Status, m.status.Message)
+	return nil
+}
+
+// fetchPresence asks the api for the presence of the contacts, for the
+// ones that did not announce it since we connected.
+func (m *model) fetchPresence() tea.Cmd {
+	names := m.sortedContacts()
+	if len(names) == 0 {
+		return nil
+	}
+	state := m.state
+	return m.startRequest("Checking who is online", false, func(ctx context.Context) tea.Msg {
+		presence, err := state.HandlerPresence(ctx, names)
+		return presenceFetched{presence: presence, err: err}
+	})
+}
+
+func (m *model) presenceFetched(result presenceFetched) {
+	// the websocket events still work without the api, it is not worth a
+	// warning
+	if result.err != nil {
+		log.Printf("could not fetch the presence of the contacts: %s", result.err)
+		return
+	}
+	for _, presence := range result.presence {
+		if _, ok := m.contacts[presence.Username]; ok {
+			m.setPresence(presence)
+		}
+	}
+}
+
+func (m *model) setPresence(presence api.Presence) {
+	m.presence[presence.Username] = presence
+	m.refreshContactList()
+}
+
+// presenceDot is the colored status of a user, hollow while unknown.
+func (m model) presenceDot(name string) string {
+	presence, ok := m.presence[name]
+	style, known := presenceStyles[presence.Status]
+	if !ok || !known {
+		return unknownPresenceStyle.Render("○")
+	}
+	return style.Render("●")
+}
+
+// presenceString describes the presence of a user, with their message.
+func presenceString(presence api.Presence) string {
+	s := string(presence.Status)
+	if presence.Message != "" {
+		s += ": " + presence.Message
+	}
+	return s
+}
+
+// roomMembers returns the other members of the current room. The rooms
+// whose members are unknown reach every contact.
+func (m model) roomMembers() []string {
+	if members := m.client.Current().Members(); len(members) > 0 {
+		return members
+	}
+	return m.sortedContacts()
+}
@@ -0,0 +1,88 @@
+package ui
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const switchProfileItem = "Switch profile"
+
+// Profile is a server the client can connect to, with the account used on
+// it.
+type Profile struct {
+	Name     string
+	Server   api.ServerInfo
+	TLS      *tls.Config
+	Username string
+}
+
+func initializeProfileView(profiles []Profile) list.Model {
+	items := []list.Item{}
+	for _, profile := range profiles {
+		items = append(items, item(fmt.Sprintf("%s (%s)", profile.Name, profile.Server.BaseURL)))
+	}
+
+	l := list.New(items, itemDelegate{}, 20, 14)
+	l.Title = "Please select a profile"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+
+	return l
+}
+
+// selectProfile points the api to the server of the profile and moves on
+// to the login.
+func (m *model) selectProfile(profile Profile) {
+	m.profile = profile
+	m.state = &api.State{Server: profile.Server, TLS: profile.TLS}
+	m.credentials[0].SetValue(profile.Username)
+	m.flow = initView
+}
+
+func (m *model) updateProfileView(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.profileList.SetWidth(msg.Width)
+		return nil
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			return tea.Quit
+		case tea.KeyEnter:
+			if len(m.profiles) == 0 {
+				return nil
+			}
+			m.selectProfile(m.profiles[m.profileList.Index()])
+			return m.resumeSession()
+		}
+	}
+
+	var cmd tea.Cmd
+	m.profileList, cmd = m.profileList.Update(msg)
+	return cmd
+}
+
+// switchProfile closes the session of the current profile and goes back to
+// the profile picker. Whatever the old client or requests report later is
+// dropped.
+func (m *model) switchProfile() {
+	if m.client != nil {
+		m.client.Close()
+	}
+	for _, r := range m.requests {
+		r.cancel()
+	}
+
+	fresh := newModel(m.profiles, m.settings)
+	fresh.lastRequest = m.lastRequest
+	// the probe is not repeated, nor the fallback to the session file
+	fresh.secretService, fresh.probed = m.secretService, m.probed
+	*m = fresh
+}
@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/keyring"
+	"github.com/CTSDM/motbwa-tui/internal/store"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// historyRekeyed is the result of unlocking the local data with the
+// previous password, its key wrapped again with the current one.
+type historyRekeyed struct {
+	account account
+	warning error
+	err     error
+}
+
+func initializeRekeyView() textinput.Model {
+	t := textinput.New()
+	t.Width = 32
+	t.Cursor.Style = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.Placeholder = "Previous password"
+	t.EchoMode = textinput.EchoPassword
+	t.EchoCharacter = '*'
+	t.PromptStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.TextStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+
+	return t
+}
+
+// startRekey asks for the previous password after a login whose password
+// did not unlock the local data. The account is loaded, and the session
+// saved with remember if any, once unlocked.
+func (m *model) startRekey(password string, remember keyring.Store) tea.Cmd {
+	m.rekeyPassword = password
+	m.rekeyRemember = remember
+	m.flow = rekeyView
+	return m.rekey.Focus()
+}
+
+func (m *model) updateRekey(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		m.loginError = ""
+		switch msg.Type {
+		case tea.KeyEnter:
+			state := m.state
+			profile := m.profile.Name
+			previous, password, remember := m.rekey.Value(), m.rekeyPassword, m.rekeyRemember
+			return m.startRequest("Unlocking the local data", true, func(ctx context.Context) tea.Msg {
+				return rekeyHistory(ctx, state, profile, previous, password, remember)
+			})
+		case tea.KeyEsc:
+			// the account cannot be used without its keys, the data stays
+			// locked until a login with the previous password
+			m.resetRekey()
+			m.flow = initView
+			m.loginError = "The local data of the account is still locked"
+			return nil
+		case tea.KeyCtrlC:
+			return tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.rekey, cmd = m.rekey.Update(msg)
+	return cmd
+}
+
+// rekeyHistory unlocks the local data with the previous password, wraps
+// its key with the current one and loads the account.
+func rekeyHistory(ctx context.Context, state *api.State, profile, previous, password string, remember keyring.Store) tea.Msg {
+	dir, err := historyPath(profile, state.User.Username)
+	if err != nil {
+		return historyRekeyed{err: err}
+	}
+	key, err := store.Rekey(dir, previous, password)
+	if err != nil {
+		return historyRekeyed{err: err}
+	}
+	account, err := loadAccount(ctx, state, profile, key)
+	if err != nil {
+		return historyRekeyed{err: err}
+	}
+
+	result := historyRekeyed{account: account}
+	if remember != nil {
+		if err := rememberSession(state, remember, key); err != nil {
+			result.warning = fmt.Errorf("could not save the session: %w", err)
+		}
+	}
+	return result
+}
+
+func (m *model) historyRekeyed(result historyRekeyed) tea.Cmd {
+	if errors.Is(result.err, store.ErrWrongPassphrase) {
+		m.loginError = "Wrong password"
+		return nil
+	} else if result.err != nil {
+		m.loginError = requestError(result.err)
+		return nil
+	}
+	m.resetRekey()
+	m.setAccount(result.account)
+	m.flow = chatView
+	cmd := m.connect()
+	if result.warning != nil {
+		m.err = result.warning
+	}
+	return cmd
+}
+
+// resetRekey forgets the passwords.
+func (m *model) resetRekey() {
+	m.rekey.Reset()
+	m.rekeyPassword = ""
+	m.rekeyRemember = nil
+}
+
+func (m model) rekeyViewString() string {
+	var b strings.Builder
+	b.WriteString("The password changed since the account was last used on this device.\n")
+	b.WriteString("Enter the previous password to unlock its keys and message history.\n\n")
+	b.WriteString(m.rekey.View())
+	b.WriteRune('\n')
+	b.WriteString("enter: unlock • esc: back\n")
+	b.WriteString(m.requestStatusString())
+	if m.loginError != "" {
+		b.WriteString(m.loginError)
+		b.WriteRune('\n')
+	}
+	return b.String()
+}
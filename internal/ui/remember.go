@@ -0,0 +1,272 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/keyring"
+	"github.com/CTSDM/motbwa-tui/internal/paths"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const sessionFile = "session.enc"
+
+// logoutCommand typed in the chat wipes the saved session.
+const logoutCommand = "/logout"
+
+// resumeSession is sent on startup to look for a saved session.
+type resumeSession struct{}
+
+// secretServiceProbed reports whether the Secret Service answered the
+// probe, it is probed in the background since it can hang.
+type secretServiceProbed struct {
+	available bool
+}
+
+func probeSecretService() tea.Msg {
+	return secretServiceProbed{available: keyring.SecretServiceAvailable()}
+}
+
+// sessionResumed is the result of a login with a saved session. none is set
+// when there was nothing saved, storeErr when the store could not be read.
+type sessionResumed struct {
+	account  account
+	none     bool
+	storeErr error
+	err      error
+}
+
+func initializeUnlockView() textinput.Model {
+	t := textinput.New()
+	t.Width = 32
+	t.Cursor.Style = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.Placeholder = "Passphrase"
+	t.EchoMode = textinput.EchoPassword
+	t.EchoCharacter = '*'
+	t.PromptStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+	t.TextStyle = lipgloss.NewStyle().Foreground(theme.Accent)
+
+	return t
+}
+
+func sessionPath(profile string) (string, error) {
+	dir, err := paths.ProfileDir(profile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sessionFile), nil
+}
+
+// sessionStore returns where the session of the current profile is saved:
+// the Secret Service when available, otherwise a file encrypted with the
+// passphrase.
+func (m *model) sessionStore(passphrase string) (keyring.Store, error) {
+	if m.secretService {
+		return keyring.NewSecretService(m.profile.Name), nil
+	}
+	path, err := sessionPath(m.profile.Name)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.NewFileStore(path, passphrase), nil
+}
+
+// secretServiceProbed picks where the sessions are saved, and looks for
+// the saved session that waited for it.
+func (m *model) secretServiceProbed(result secretServiceProbed) tea.Cmd {
+	m.secretService = result.available
+	m.probed = true
+	if m.resumeAfterProbe {
+		m.resumeAfterProbe = false
+		return m.resumeSession()
+	}
+	return nil
+}
+
+// resumeSession logs in with the session saved for the profile, if any. A
+// session saved in a file waits for its passphrase in unlockView.
+func (m *model) resumeSession() tea.Cmd {
+	if !m.probed {
+		m.resumeAfterProbe = true
+		return nil
+	}
+	state := m.state
+	profile := m.profile.Name
+	if m.secretService {
+		store := keyring.NewSecretService(profile)
+		return m.startRequest("Restoring the session", true, func(ctx context.Context) tea.Msg {
+			return resume(ctx, state, profile, store)
+		})
+	}
+
+	path, err := sessionPath(profile)
+	if err != nil {
+		m.loginError = err.Error()
+		return nil
+	}
+	if !keyring.FileExists(path) {
+		return nil
+	}
+	m.flow = unlockView
+	return m.unlock.Focus()
+}
+
+func (m *model) updateUnlock(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		m.loginError = ""
+		switch msg.Type {
+		case tea.KeyEnter:
+			store, err := m.sessionStore(m.unlock.Value())
+			if err != nil {
+				m.loginError = err.Error()
+				return nil
+			}
+			state := m.state
+			profile := m.profile.Name
+			return m.startRequest("Unlocking the session", true, func(ctx context.Context) tea.Msg {
+				return resume(ctx, state, profile, store)
+			})
+		case tea.KeyCtrlB:
+			// the saved session is kept, a login with the password
+			// replaces it only if asked to
+			m.unlock.Reset()
+			m.flow = initView
+			return nil
+		case tea.KeyCtrlC:
+			return tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.unlock, cmd = m.unlock.Update(msg)
+	return cmd
+}
+
+func (m *model) sessionResumed(result sessionResumed) tea.Cmd {
+	if result.none {
+		return nil
+	}
+	// the Secret Service may fail after all, e.g. a locked or missing
+	// keyring, the file store takes over
+	if result.storeErr != nil && m.secretService {
+		log.Printf("could not read the Secret Service, using the session file instead: %v", result.storeErr)
+		m.secretService = false
+		return m.resumeSession()
+	}
+	if result.storeErr != nil {
+		result.err = result.storeErr
+	}
+	if errors.Is(result.err, keyring.ErrWrongPassphrase) {
+		m.loginError = requestError(result.err)
+		return nil
+	}
+	m.unlock.Reset()
+	if result.err != nil {
+		m.flow = initView
+		m.loginError = "Could not resume the session: " + requestError(result.err)
+		return nil
+	}
+	m.setAccount(result.account)
+	m.flow = chatView
+	return m.connect()
+}
+
+// resume restores the saved session and checks it is still valid by
+// refreshing the tokens. A session the server rejects is wiped.
+func resume(ctx context.Context, state *api.State, profile string, store keyring.Store) tea.Msg {
+	saved, err := store.Load()
+	if errors.Is(err, keyring.ErrNoSession) {
+		return sessionResumed{none: true}
+	} else if errors.Is(err, keyring.ErrWrongPassphrase) {
+		return sessionResumed{err: err}
+	} else if err != nil {
+		return sessionResumed{storeErr: err}
+	}
+
+	state.RestoreSession(saved.UserID, saved.Username, saved.Token, saved.RefreshToken)
+	keepSessionUpdated(state, store, saved.HistoryKey)
+	if err := state.RefreshTokens(ctx, saved.Token); err != nil {
+		if errors.Is(err, api.ErrRefreshRejected) {
+			state.OnTokensChanged(nil)
+			if err := store.Delete(); err != nil {
+				log.Printf("could not delete the expired session: %v", err)
+			}
+		}
+		return sessionResumed{err: err}
+	}
+
+	account, err := loadAccount(ctx, state, profile, saved.HistoryKey)
+	return sessionResumed{account: account, err: err}
+}
+
+// rememberSession saves the session of the logged in user.
+func rememberSession(state *api.State, store keyring.Store, historyKey []byte) error {
+	keepSessionUpdated(state, store, historyKey)
+	token, refreshToken := state.Tokens()
+	return store.Save(sessionOf(state, token, refreshToken, historyKey))
+}
+
+// keepSessionUpdated saves the tokens again after every refresh, the server
+// does not accept the old refresh token any more.
+func keepSessionUpdated(state *api.State, store keyring.Store, historyKey []byte) {
+	state.OnTokensChanged(func(token, refreshToken string) {
+		if err := store.Save(sessionOf(state, token, refreshToken, historyKey)); err != nil {
+			log.Printf("could not update the saved session: %v", err)
+		}
+	})
+}
+
+func sessionOf(state *api.State, token, refreshToken string, historyKey []byte) keyring.Session {
+	return keyring.Session{
+		UserID:       state.User.UserID,
+		Username:     state.User.Username,
+		Token:        token,
+		RefreshToken: refreshToken,
+		HistoryKey:   historyKey,
+	}
+}
+
+// logout wipes the saved session of the profile and goes back to the
+// login.
+func (m *model) logout() {
+	m.state.OnTokensChanged(nil)
+	errs := []error{}
+	if m.secretService {
+		errs = append(errs, keyring.NewSecretService(m.profile.Name).Delete())
+	}
+	// a file may be left from a time without Secret Service
+	path, err := sessionPath(m.profile.Name)
+	if err == nil {
+		err = keyring.NewFileStore(path, "").Delete()
+	}
+	errs = append(errs, err)
+
+	profile := m.profile
+	m.switchProfile()
+	m.selectProfile(profile)
+	if err := errors.Join(errs...); err != nil {
+		m.loginError = fmt.Sprintf("Could not wipe the saved session: %v", err)
+	}
+}
+
+func (m model) unlockViewString() string {
+	var b strings.Builder
+	b.WriteString("Unlock the saved session of the " + m.profile.Name + " profile\n\n")
+	b.WriteString(m.unlock.View())
+	b.WriteRune('\n')
+	b.WriteString("enter: unlock • ctrl+b: log in with the password\n")
+	b.WriteString(m.requestStatusString())
+	if m.loginError != "" {
+		b.WriteString(m.loginError)
+		b.WriteRune('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,127 @@
+package ui
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// requestTimeout bounds every call to the api.
+const requestTimeout = 15 * time.Second
+
+var errCancelled = errors.New("cancelled")
+
+// request is an api call running in a tea.Cmd. A foreground request blocks
+// the view that started it until it is done or cancelled with esc.
+type request struct {
+	label      string
+	foreground bool
+	cancel     context.CancelFunc
+}
+
+// requestDone carries the result of a request back to Update.
+type requestDone struct {
+	id     int
+	result tea.Msg
+}
+
+// startRequest runs call outside of the Update loop. The message returned
+// by call is delivered to handleResult.
+func (m *model) startRequest(label string, foreground bool, call func(ctx context.Context) tea.Msg) tea.Cmd {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	m.lastRequest++
+	id := m.lastRequest
+	m.requests[id] = request{label: label, foreground: foreground, cancel: cancel}
+
+	return tea.Batch(
+		m.spinner.Tick,
+		func() tea.Msg {
+			return requestDone{id: id, result: call(ctx)}
+		},
+	)
+}
+
+// finishRequest reports whether the request is still expected, a cancelled
+// request delivers its result all the same.
+func (m *model) finishRequest(id int) bool {
+	r, ok := m.requests[id]
+	if !ok {
+		return false
+	}
+	r.cancel()
+	delete(m.requests, id)
+	return true
+}
+
+// cancelForeground cancels the request the current view waits for. The
+// background requests end on their own.
+func (m *model) cancelForeground() {
+	for id, r := range m.requests {
+		if r.foreground {
+			r.cancel()
+			delete(m.requests, id)
+		}
+	}
+}
+
+// waiting returns the foreground request in flight, if any.
+func (m model) waiting() (request, bool) {
+	for _, r := range m.requests {
+		if r.foreground {
+			return r, true
+		}
+	}
+	return request{}, false
+}
+
+func (m model) busy() bool {
+	return len(m.requests) > 0
+}
+
+// requestError describes the failure of a request to the user.
+func requestError(err error) string {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostnameErr      x509.HostnameError
+		apiErr           *api.Error
+	)
+	switch {
+	case errors.Is(err, errCancelled):
+		return "Cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "The server did not answer in time"
+	case errors.Is(err, api.ErrPinMismatch):
+		return "Connection refused: the server certificate does not match the pinned key"
+	case errors.As(err, &unknownAuthority):
+		return "Connection refused: the server certificate is signed by an unknown authority, configure its CA bundle"
+	case errors.As(err, &invalidCert), errors.As(err, &hostnameErr):
+		return "Connection refused: invalid server certificate (" + err.Error() + ")"
+	case errors.Is(err, api.ErrUsernameTaken):
+		return "That username is already taken, pick another one"
+	case errors.Is(err, api.ErrUnauthorized), errors.Is(err, api.ErrRefreshRejected):
+		return "The session expired, log in again"
+	case errors.Is(err, api.ErrRateLimited):
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			return fmt.Sprintf("Too many requests, try again in %v", apiErr.RetryAfter.Round(time.Second))
+		}
+		return "Too many requests, try again in a moment"
+	case errors.Is(err, api.ErrServerUnavailable):
+		return "The server is unavailable right now, try again later"
+	}
+	return err.Error()
+}
+
+// requestStatusString shows the spinner of the foreground request.
+func (m model) requestStatusString() string {
+	r, ok := m.waiting()
+	if !ok {
+		return ""
+	}
+	return m.spinner.View() + " " + r.label + " (esc to cancel)\n"
+}
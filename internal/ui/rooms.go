@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
+)
+
+const sidebarWidth = 22
+
+var (
+	sidebarStyle      = lipgloss.NewStyle().Width(sidebarWidth).PaddingRight(1).Border(lipgloss.NormalBorder(), false, true, false, false)
+	currentRoomStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("170"))
+	unreadCountStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	sidebarTitleStyle = lipgloss.NewStyle().Underline(true)
+)
+
+// updateRoom handles the input to create a room, or to join one when the
+// input is a room ID.
+func (m *model) updateRoom(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		m.loginError = ""
+		switch msg.Type {
+		case tea.KeyEnter:
+			value := strings.TrimSpace(m.newRoom.Value())
+			if value == "" {
+				m.loginError = "The room name cannot be empty"
+				return nil
+			}
+
+			var err error
+			id, parseErr := uuid.Parse(value)
+			if parseErr == nil {
+				_, err = m.client.JoinRoom(id)
+			} else {
+				_, err = m.client.CreateRoom(value)
+			}
+			if err != nil {
+				m.loginError = err.Error()
+				return nil
+			}
+			m.newRoom.Reset()
+			m.flow = chatView
+			m.switchRoom(m.client.Current().ID())
+			if parseErr == nil {
+				return m.backfillRoom(id)
+			}
+			return nil
+		case tea.KeyCtrlB:
+			m.flow = chatView
+			return nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.newRoom, cmd = m.newRoom.Update(msg)
+	return cmd
+}
+
+// cycleRoom makes the next (delta 1) or previous (delta -1) room current.
+func (m *model) cycleRoom(delta int) {
+	rooms := m.client.RoomList()
+	current := m.client.Current().ID()
+	for i, room := range rooms {
+		if room.ID() == current {
+			next := rooms[(i+delta+len(rooms))%len(rooms)]
+			m.switchRoom(next.ID())
+			return
+		}
+	}
+}
+
+func (m *model) switchRoom(id uuid.UUID) {
+	m.client.SwitchRoom(id)
+	delete(m.roomUnread, id)
+	m.saveRooms()
+	m.refreshMessages()
+}
+
+func (m *model) leaveRoom() {
+	if err := m.client.LeaveRoom(m.client.Current().ID()); err != nil {
+		m.err = err
+		return
+	}
+	m.switchRoom(m.client.Current().ID())
+}
+
+func (m model) roomSidebarView() string {
+	var b strings.Builder
+	b.WriteString(sidebarTitleStyle.Render("Rooms") + "\n")
+
+	current := m.client.Current().ID()
+	for _, room := range m.client.RoomList() {
+		line := room.Name()
+		if room.Direct() {
+			line += " " + m.presenceDot(room.Peer())
+		}
+		if count := m.roomUnread[room.ID()]; count > 0 {
+			line += " " + unreadCountStyle.Render(fmt.Sprintf("(%d)", count))
+		}
+		if room.ID() == current {
+			line = currentRoomStyle.Render("> " + line)
+		} else {
+			line = "  " + line
+		}
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n" + sidebarTitleStyle.Render("Members") + "\n")
+	for _, name := range m.roomMembers() {
+		b.WriteString(m.presenceDot(name) + " " + name + "\n")
+	}
+
+	return sidebarStyle.Height(m.viewport.Height).Render(b.String())
+}
+
+func (m model) roomViewString() string {
+	var b strings.Builder
+	b.WriteString("Create a room, or paste the ID of a room to join it\n\n")
+	b.WriteString(m.newRoom.View())
+	b.WriteRune('\n')
+	b.WriteString("current room ID: " + m.client.Current().ID().String() + "\n")
+	b.WriteString("enter: confirm • ctrl+b: back\n")
+
+	if m.loginError != "" {
+		b.WriteString(m.loginError)
+		b.WriteRune('\n')
+	}
+
+	return b.String()
+}
+
+func isCurrentRoom(client *ws.ClientManager, message ws.Message) bool {
+	return message.Room == client.Current().ID()
+}
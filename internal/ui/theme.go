@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is the set of colors of the interface. An empty color leaves the
+// terminal default.
+type Theme struct {
+	// Accent colors the inputs and the spinner.
+	Accent lipgloss.Color
+	// Highlight colors the selected items.
+	Highlight lipgloss.Color
+	Sender    lipgloss.Color
+	Muted     lipgloss.Color
+	// Success, Warning and Error color the states: connection, presence,
+	// delivery and key verification.
+	Success lipgloss.Color
+	Warning lipgloss.Color
+	Error   lipgloss.Color
+	// Info colors the read receipts.
+	Info lipgloss.Color
+}
+
+var themes = map[string]Theme{
+	"default": {Accent: "205", Highlight: "170", Sender: "5", Muted: "8", Success: "10", Warning: "11", Error: "9", Info: "12"},
+	"light":   {Accent: "125", Highlight: "90", Sender: "54", Muted: "244", Success: "28", Warning: "130", Error: "160", Info: "25"},
+	"mono":    {},
+}
+
+// theme is the active theme, set by InitialModel.
+var theme = themes["default"]
+
+// LookupTheme returns the theme called name.
+func LookupTheme(name string) (Theme, error) {
+	t, ok := themes[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(themes))
+		return Theme{}, fmt.Errorf("unknown theme %q, the themes are: %s", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// applyTheme recolors the shared styles.
+func applyTheme(t Theme) {
+	theme = t
+	selectedItemStyle = selectedItemStyle.Foreground(t.Highlight)
+	currentRoomStyle = currentRoomStyle.Foreground(t.Highlight)
+	pendingStyle = pendingStyle.Foreground(t.Muted)
+	readStyle = readStyle.Foreground(t.Info)
+	failedStyle = failedStyle.Foreground(t.Error)
+	timeStyle = timeStyle.Foreground(t.Muted)
+	separatorStyle = separatorStyle.Foreground(t.Muted)
+	typingStyle = typingStyle.Foreground(t.Muted)
+	hintStyle = hintStyle.Foreground(t.Muted)
+	tombstoneStyle = tombstoneStyle.Foreground(t.Muted)
+	selectedMarkerStyle = selectedMarkerStyle.Foreground(t.Highlight)
+	unreadCountStyle = unreadCountStyle.Foreground(t.Warning)
+	warningStyle = warningStyle.Foreground(t.Error)
+	verifiedStyle = verifiedStyle.Foreground(t.Success)
+	presenceStyles[api.PresenceOnline] = presenceStyles[api.PresenceOnline].Foreground(t.Success)
+	presenceStyles[api.PresenceAway] = presenceStyles[api.PresenceAway].Foreground(t.Warning)
+	presenceStyles[api.PresenceOffline] = presenceStyles[api.PresenceOffline].Foreground(t.Muted)
+	unknownPresenceStyle = unknownPresenceStyle.Foreground(t.Muted)
+}
@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var typingStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8")).Italic(true)
+
+// typingExpired asks to drop the indicators that were not renewed in time.
+type typingExpired struct{}
+
+// setTyping shows or hides the indicator of a user in a room. A shown
+// indicator expires unless the user keeps typing.
+func (m *model) setTyping(msg ws.TypingChanged) tea.Cmd {
+	users := m.typing[msg.Room]
+	if !msg.Typing {
+		delete(users, msg.User)
+		return nil
+	}
+	if users == nil {
+		users = make(map[string]time.Time)
+		m.typing[msg.Room] = users
+	}
+	users[msg.User] = time.Now().Add(ws.TypingTimeout)
+	return tea.Tick(ws.TypingTimeout, func(time.Time) tea.Msg { return typingExpired{} })
+}
+
+func (m *model) expireTyping() {
+	now := time.Now()
+	for room, users := range m.typing {
+		maps.DeleteFunc(users, func(_ string, expiry time.Time) bool { return !expiry.After(now) })
+		if len(users) == 0 {
+			delete(m.typing, room)
+		}
+	}
+}
+
+// typingStatusString names the users typing in the current room, it fits
+// in the line above the textarea.
+func (m model) typingStatusString() string {
+	users := slices.Sorted(maps.Keys(m.typing[m.client.Current().ID()]))
+	var text string
+	switch len(users) {
+	case 0:
+		return ""
+	case 1:
+		text = users[0] + " is typing…"
+	case 2:
+		text = users[0] + " and " + users[1] + " are typing…"
+	default:
+		text = fmt.Sprintf("%d people are typing…", len(users))
+	}
+	return typingStyle.Render(text)
+}
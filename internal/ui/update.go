@@ -2,119 +2,186 @@ package ui
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"strings"
+	"errors"
+	"sort"
 
-	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/CTSDM/motbwa-tui/internal/keyring"
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
 )
 
-const gap = "\n\n"
-
-type WebSocketMessageReceived struct {
-	Message ws.Message
-}
-
 func (m model) Init() tea.Cmd {
-	return nil
+	// a profile given on startup may have a saved session
+	if m.flow == initView {
+		return tea.Batch(probeSecretService, func() tea.Msg { return resumeSession{} })
+	}
+	return probeSecretService
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// the client keeps delivering while another view is shown
+	switch msg := msg.(type) {
+	case clientMsg:
+		if msg.client != m.client {
+			return m, nil
+		}
+		cmd := m.updateClient(msg.msg)
+		if m.flow == chatView {
+			m.markMessagesRead()
+		}
+		return m, cmd
+
+	case resumeSession:
+		return m, m.resumeSession()
+
+	case secretServiceProbed:
+		return m, m.secretServiceProbed(msg)
+
+	case typingExpired:
+		m.expireTyping()
+		return m, nil
+
+	case idleCheck:
+		if msg.client != m.client {
+			return m, nil
+		}
+		m.updateIdle()
+		return m, m.checkIdle()
+
+	case requestDone:
+		if !m.finishRequest(msg.id) {
+			return m, nil
+		}
+		cmd := m.handleResult(msg.result)
+		return m, cmd
+
+	case spinner.TickMsg:
+		if !m.busy() {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case tea.KeyMsg:
+		m.markActive()
+		// the view that started a request waits for it, esc gives up
+		if _, ok := m.waiting(); ok {
+			switch msg.Type {
+			case tea.KeyEsc:
+				m.cancelForeground()
+				m.loginError = requestError(errCancelled)
+				return m, nil
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+	}
+
 	switch m.flow {
+	case profileView:
+		cmd := m.updateProfileView(msg)
+		return m, cmd
+
 	case initView:
 		cmd := m.updateInitView(msg)
 		return m, cmd
 
+	case unlockView:
+		cmd := m.updateUnlock(msg)
+		return m, cmd
+
+	case rekeyView:
+		cmd := m.updateRekey(msg)
+		return m, cmd
+
 	case loginView, signUpView:
 		cmd := m.updateInputs(msg)
-		if m.flow == chatView {
-			// websocket connection
-			clientManager, err := ws.CreateConnection(m.state)
-			if err != nil {
-				log.Fatalf("Could not establish the websocket connection: %s", err)
-			}
-			m.client = clientManager
-		}
 		return m, cmd
 
 	case addContactView:
 		cmd := m.updateContact(msg)
 		return m, cmd
 
+	case verifyContactView:
+		cmd := m.updateVerifyContact(msg)
+		return m, cmd
+
+	case roomView:
+		cmd := m.updateRoom(msg)
+		return m, cmd
+
+	case contactsView:
+		cmd := m.updateContactList(msg)
+		return m, cmd
+
 	case chatView:
-		var (
-			tiChatCmd tea.Cmd
-			vpChatCmd tea.Cmd
-		)
-
-		m.textarea, tiChatCmd = m.textarea.Update(msg)
-		m.viewport, vpChatCmd = m.viewport.Update(msg)
-
-		switch msg := msg.(type) {
-		case tea.WindowSizeMsg:
-			m.viewport.Width = msg.Width
-			m.textarea.SetWidth(msg.Width)
-			m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap)
-
-			if len(m.messages) > 0 {
-				// Wrap content before setting it.
-				strArray := []string{}
-				for _, message := range m.messages {
-					strArray = append(strArray, fmt.Sprintf("%s: %s", message.Sender, message.Content))
-				}
-				m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(strArray, "\n")))
-			}
-			m.viewport.GotoBottom()
+		cmd := m.updateChat(msg)
+		m.markMessagesRead()
+		return m, cmd
+	}
 
-		case tea.KeyMsg:
-			switch msg.Type {
-			case tea.KeyCtrlA:
-				m.flow = addContactView
-				return m, nil
+	return m, nil
+}
 
-			case tea.KeyCtrlC:
-				m.client.Close()
-				fmt.Println(m.textarea.Value())
-				return m, tea.Quit
+// handleResult applies the result of a request started with startRequest.
+func (m *model) handleResult(result tea.Msg) tea.Cmd {
+	switch result := result.(type) {
+	case signedUp:
+		if result.err != nil {
+			m.loginError = requestError(result.err)
+			return nil
+		}
+		m.flow = initView
+		return m.resetInputs()
 
-			case tea.KeyEnter:
-				msg := m.textarea.Value()
-				m.client.SetEgress(msg)
-				newMessage := ws.Message{
-					Sender:  m.senderStyle.Render("You"),
-					Content: m.textarea.Value(),
-				}
-				m.messages = append(m.messages, newMessage)
-				strArray := []string{}
-				for _, message := range m.messages {
-					strArray = append(strArray, fmt.Sprintf("%s: %s", m.senderStyle.Render(message.Sender), message.Content))
-				}
-				m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(strArray, "\n")))
-				m.textarea.Reset()
-				m.viewport.GotoBottom()
-			}
+	case loggedIn:
+		if errors.Is(result.err, api.ErrUnauthorized) {
+			m.loginError = "Wrong username or password"
+			return nil
+		} else if result.err != nil {
+			m.loginError = requestError(result.err)
+			return nil
+		}
+		if result.historyLocked {
+			return tea.Batch(m.resetInputs(), m.startRekey(result.password, result.remember))
+		}
+		m.setAccount(result.account)
+		m.flow = chatView
+		cmd := tea.Batch(m.resetInputs(), m.connect())
+		if result.warning != nil {
+			m.err = result.warning
+		}
+		return cmd
 
-		case WebSocketMessageReceived:
-			newMessage := ws.Message{
-				Sender:  m.senderStyle.Render(msg.Message.Sender),
-				Content: msg.Message.Content,
-			}
-			m.messages = append(m.messages, newMessage)
-			strArray := []string{}
-			for _, message := range m.messages {
-				strArray = append(strArray, fmt.Sprintf("%s: %s", message.Sender, message.Content))
-			}
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(strArray, "\n")))
-			m.textarea.Reset()
-			m.viewport.GotoBottom()
+	case sessionResumed:
+		return m.sessionResumed(result)
+
+	case historyRekeyed:
+		return m.historyRekeyed(result)
+
+	case contactAdded:
+		m.contactAdded(result)
+
+	case contactRemoved:
+		m.contactRemoved(result)
+
+	case contactsFetched:
+		m.contactsFetched(result)
+		if result.err == nil {
+			return m.fetchPresence()
 		}
 
-		return m, tea.Batch(tiChatCmd, vpChatCmd, listenToWebSocketMessages(m.client.MessageChannel()))
-	}
+	case presenceFetched:
+		m.presenceFetched(result)
 
-	return m, nil
+	case historyFetched:
+		m.historyFetched(result)
+	}
+	return nil
 }
 
 func (m *model) updateInitView(msg tea.Msg) tea.Cmd {
@@ -160,23 +227,54 @@ func (m *model) updateContact(msg tea.Msg) tea.Cmd {
 				m.loginError = "User already in contact list"
 				return nil
 			}
-			if err := m.state.HandlerCheckUserExists(context.Background(), contactName); err != nil {
+			state := m.state
+			return m.startRequest("Adding "+contactName, true, func(ctx context.Context) tea.Msg {
+				profile, err := state.HandlerAddContact(ctx, contactName)
+				return contactAdded{profile: profile, err: err}
+			})
+		case tea.KeyCtrlB:
+			m.flow = chatView
+			return nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.newContact, cmd = m.newContact.Update(msg)
+	return cmd
+}
+
+func (m *model) updateVerifyContact(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		m.loginError = ""
+		switch msg.Type {
+		case tea.KeyEnter:
+			if err := m.trust.Verify(m.verifyContact, m.contacts[m.verifyContact].PublicKey); err != nil {
 				m.loginError = err.Error()
 				return nil
 			}
-			m.contacts[contactName] = struct{}{}
-			m.newContact.Reset()
+			m.verifyContact = ""
 			m.flow = chatView
 			return nil
 		case tea.KeyCtrlB:
+			m.verifyContact = ""
 			m.flow = chatView
 			return nil
 		}
 	}
+	return nil
+}
 
-	var cmd tea.Cmd
-	m.newContact, cmd = m.newContact.Update(msg)
-	return cmd
+// changedKeyContacts lists the contacts whose verified key was replaced.
+func (m *model) changedKeyContacts() []string {
+	changed := []string{}
+	for name := range m.contacts {
+		if m.trust.Status(name) == e2e.KeyChanged {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
 }
 
 func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
@@ -189,39 +287,56 @@ func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
 	case tea.KeyMsg:
 		switch msg.Type {
 		case tea.KeyTab, tea.KeyShiftTab:
-			// remove focus from previous index
-			// with delta and sign we can jump forward/backward
+			// move the focus forward or backward over the visible inputs
 			delta := 1
-			sign := 1
 			if msg.Type == tea.KeyShiftTab {
-				sign = len(m.credentials) - 1
+				delta = -1
 			}
-			m.credentials[m.focusIndex].Blur()
-			m.focusIndex = (m.focusIndex + delta*sign) % len(m.credentials) * sign
-			cmds[m.focusIndex] = m.credentials[m.focusIndex].Focus()
-			return tea.Batch(cmds...)
+			return m.focusInput((m.focusIndex + delta + m.visibleInputs()) % m.visibleInputs())
+
+		case tea.KeyCtrlT:
+			if m.flow == loginView {
+				m.remember = !m.remember
+				if m.focusIndex >= m.visibleInputs() {
+					return m.focusInput(0)
+				}
+			}
+			return nil
 
 		case tea.KeyEnter:
 			m.loginError = ""
 			// the enter key validates and then submits the inputs
 			username := m.credentials[0].Value()
 			password := m.credentials[1].Value()
-			// we call the api handler
+			state := m.state
+			profile := m.profile.Name
+			// the api handler runs in the background, the result is
+			// handled by handleResult
 			switch m.flow {
 			case signUpView:
-				if err := m.state.HandlerCreateUser(context.Background(), username, password); err != nil {
-					m.loginError = err.Error()
-					return tea.Batch(cmds...)
-				}
+				return m.startRequest("Creating the account", true, func(ctx context.Context) tea.Msg {
+					return signUp(ctx, state, profile, username, password)
+				})
 			case loginView:
-				if err := m.state.HandlerLogin(context.Background(), username, password); err != nil {
-					m.loginError = err.Error()
-					return tea.Batch(cmds...)
+				var remember keyring.Store
+				if m.remember {
+					passphrase := m.credentials[2].Value()
+					if !m.secretService && passphrase == "" {
+						m.loginError = "Choose a passphrase to protect the saved session"
+						return nil
+					}
+					store, err := m.sessionStore(passphrase)
+					if err != nil {
+						m.loginError = err.Error()
+						return nil
+					}
+					remember = store
 				}
+				return m.startRequest("Logging in", true, func(ctx context.Context) tea.Msg {
+					return login(ctx, state, profile, username, password, remember)
+				})
 			}
-
-			m.flow += 1
-			return m.resetInputs()
+			return nil
 
 		case tea.KeyCtrlC:
 			return tea.Quit
@@ -235,21 +350,27 @@ func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
 }
 
 func (m *model) resetInputs() tea.Cmd {
-	m.focusIndex = 0
 	m.loginError = ""
-	cmds := make([]tea.Cmd, len(m.credentials))
+	m.remember = false
 	for i := range m.credentials {
 		m.credentials[i].Reset()
 	}
-	m.credentials[1].Blur()
-	cmds[0] = m.credentials[0].Focus()
+	return m.focusInput(0)
+}
 
-	return tea.Batch(cmds...)
+func (m *model) focusInput(index int) tea.Cmd {
+	for i := range m.credentials {
+		m.credentials[i].Blur()
+	}
+	m.focusIndex = index
+	return m.credentials[index].Focus()
 }
 
-func listenToWebSocketMessages(msg <-chan ws.Message) tea.Cmd {
-	return func() tea.Msg {
-		msgChan := <-msg
-		return WebSocketMessageReceived{Message: msgChan}
+// visibleInputs is the number of credentials inputs shown, the passphrase
+// is only asked for a session saved in a file.
+func (m model) visibleInputs() int {
+	if m.flow == loginView && m.remember && !m.secretService {
+		return len(m.credentials)
 	}
+	return 2
 }
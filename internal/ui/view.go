@@ -3,24 +3,51 @@ package ui
 import (
 	"fmt"
 	"strings"
+
+	"github.com/CTSDM/motbwa-tui/internal/ws"
+	"github.com/charmbracelet/lipgloss"
 )
 
 func (m model) View() string {
 	s := "Chat application 못봐\n"
+	if len(m.profiles) > 1 {
+		s = "Chat application 못봐 · " + m.profile.Name + " (ctrl+g: switch profile)\n"
+	}
 	switch m.flow {
+	case profileView:
+		return "\n" + m.profileList.View()
+
 	case initView:
 		// here the user select to either login or create user
 		// this will be a simple list!
-		return "\n" + m.initList.View()
+		view := "\n" + m.initList.View()
+		if m.loginError != "" {
+			view += "\n" + m.loginError + "\n"
+		}
+		return view
+
+	case unlockView:
+		return m.unlockViewString()
+
+	case rekeyView:
+		return m.rekeyViewString()
 
 	case signUpView, loginView:
 		var b strings.Builder
 
-		for i := range m.credentials {
+		for i := range m.visibleInputs() {
 			b.WriteString(m.credentials[i].View())
 			b.WriteRune('\n')
 		}
+		if m.flow == loginView {
+			check := "[ ]"
+			if m.remember {
+				check = "[x]"
+			}
+			b.WriteString(check + " Stay logged in (ctrl+t)\n")
+		}
 
+		b.WriteString(m.requestStatusString())
 		if m.loginError != "" {
 			b.WriteString(m.loginError)
 			b.WriteRune('\n')
@@ -30,18 +57,32 @@ func (m model) View() string {
 
 	case chatView:
 		return fmt.Sprintf(
-			"%s\n%s%s%s",
+			"%s%s\n%s%s%s%s%s",
 			s,
-			m.viewport.View(),
-			gap,
+			m.connectionStatusString(),
+			m.keyWarningString(),
+			m.chatErrorString(),
+			lipgloss.JoinHorizontal(lipgloss.Top, m.roomSidebarView(), m.viewport.View()),
+			// the status takes the blank line of the gap
+			"\n"+m.chatStatusString()+"\n",
 			m.textarea.View(),
 		)
 
+	case verifyContactView:
+		return m.verifyContactViewString()
+
+	case roomView:
+		return m.roomViewString()
+
+	case contactsView:
+		return m.contactsViewString()
+
 	case addContactView:
 		var b strings.Builder
 		b.WriteString(m.newContact.View())
 		b.WriteRune('\n')
 
+		b.WriteString(m.requestStatusString())
 		if m.loginError != "" {
 			b.WriteString(m.loginError)
 			b.WriteRune('\n')
@@ -52,3 +93,32 @@ func (m model) View() string {
 
 	return "something went wrong..."
 }
+
+func (m model) connectionStatusString() string {
+	style := lipgloss.NewStyle().Foreground(theme.Warning)
+	switch m.connStatus {
+	case ws.Online:
+		style = style.Foreground(theme.Success)
+	case ws.Offline:
+		style = style.Foreground(theme.Error)
+	}
+
+	status := style.Render("● " + m.connStatus.String())
+	if m.connStatus == ws.Offline && m.connErr != nil {
+		status += " (" + requestError(m.connErr) + ")"
+	}
+	if m.connStatus == ws.Online && m.status.Status != "" {
+		status += " · you: " + presenceStyles[m.status.Status].Render("●") + " " + presenceString(m.status)
+	}
+	if m.busy() {
+		status += " " + m.spinner.View()
+	}
+	return status
+}
+
+func (m model) chatErrorString() string {
+	if m.err == nil {
+		return ""
+	}
+	return warningStyle.Render(m.err.Error()) + "\n"
+}
@@ -1,11 +1,18 @@
 package ws
 
 import (
+	"crypto/ecdh"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -15,22 +22,47 @@ type WebsocketConnection interface {
 	WriteMessage(messageType int, data []byte) error
 	WriteControl(messageType int, data []byte, deadline time.Time) error
 	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
 	NextReader() (messageType int, r io.Reader, err error)
 	Close() error
 }
 
-type Room struct {
-	id   uuid.UUID
-	name string
-}
+// Dialer opens a new websocket connection, it is called again every time
+// the connection is lost.
+type Dialer func() (WebsocketConnection, error)
 
 type ClientManager struct {
-	Conn        WebsocketConnection
-	CurrentRoom *Room
-	Rooms       []Room
-	msgChan     chan Message
-	egress      chan string
-	user        userInfo
+	// CurrentRoom and Rooms are guarded by roomsMu, use Current and
+	// RoomList to read them.
+	CurrentRoom   *Room
+	Rooms         []Room
+	roomsMu       sync.RWMutex
+	msgChan       chan Message
+	notifications chan Notification
+	outbox        *Outbox
+	outboxReady   chan struct{}
+	user          userInfo
+	sessions      *e2e.Sessions
+	peersMu       sync.RWMutex
+	peers         map[string]peerInfo
+	typing        typingState
+	presenceMu    sync.RWMutex
+	presence      api.Presence
+
+	dial      Dialer
+	heartbeat Heartbeat
+	connMu    sync.RWMutex
+	conn      WebsocketConnection
+	writeMu   sync.Mutex
+	// done is closed when the user closes the client, stopped once the
+	// supervisor gave up the connection afterwards.
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	// workers are the goroutines that report on msgChan and notifications,
+	// both are closed once they all returned.
+	workers sync.WaitGroup
 }
 
 type userInfo struct {
@@ -38,88 +70,481 @@ type userInfo struct {
 	id   uuid.UUID
 }
 
-func NewClientManager(c WebsocketConnection, username string, userID uuid.UUID, r Room) *ClientManager {
+const (
+	outboxRetryInterval = 5 * time.Second
+	maxSendAttempts     = 5
+	// writeWait bounds every write, the UI waits for the events it sends
+	// and a stalled connection must not freeze it.
+	writeWait = 2 * time.Second
+)
+
+var (
+	errNoRecipients = errors.New("no contact with a known public key to send the message to")
+	errOffline      = errors.New("the websocket connection is not established")
+)
+
+// NewClientManager creates a client subscribed to rooms, the first one is
+// the current room. There must be at least one room.
+func NewClientManager(dial Dialer, heartbeat Heartbeat, username string, userID uuid.UUID, sessions *e2e.Sessions, outbox *Outbox, rooms []Room) *ClientManager {
+	current := rooms[0]
 	return &ClientManager{
-		Conn:        c,
-		CurrentRoom: &r,
-		Rooms:       []Room{r},
-		msgChan:     make(chan Message),
-		egress:      make(chan string),
-		user:        userInfo{name: username, id: userID},
+		CurrentRoom:   &current,
+		Rooms:         slices.Clone(rooms),
+		msgChan:       make(chan Message),
+		notifications: make(chan Notification, 16),
+		outbox:        outbox,
+		outboxReady:   make(chan struct{}, 1),
+		user:          userInfo{name: username, id: userID},
+		sessions:      sessions,
+		peers:         make(map[string]peerInfo),
+		dial:          dial,
+		heartbeat:     heartbeat,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 }
 
+// start runs the supervisor and the outbox sender until Close is called.
+// The listeners see their channel closed once both stopped.
+func (c *ClientManager) start() {
+	c.workers.Add(2)
+	go func() {
+		defer c.workers.Done()
+		c.supervise()
+	}()
+	go func() {
+		defer c.workers.Done()
+		c.sendMessages()
+	}()
+	go func() {
+		c.workers.Wait()
+		close(c.msgChan)
+		close(c.notifications)
+	}()
+}
+
+type peerInfo struct {
+	id  uuid.UUID
+	key *ecdh.PublicKey
+}
+
+// AddPeer registers the public key of a contact so that outgoing messages
+// are encrypted for it and incoming messages from it can be decrypted.
+func (c *ClientManager) AddPeer(username string, id uuid.UUID, key *ecdh.PublicKey) {
+	c.peersMu.Lock()
+	defer c.peersMu.Unlock()
+	c.peers[username] = peerInfo{id: id, key: key}
+}
+
+// RemovePeer stops encrypting messages for the contact.
+func (c *ClientManager) RemovePeer(username string) {
+	c.peersMu.Lock()
+	defer c.peersMu.Unlock()
+	delete(c.peers, username)
+}
+
+func (c *ClientManager) peer(username string) (peerInfo, bool) {
+	c.peersMu.RLock()
+	defer c.peersMu.RUnlock()
+	p, ok := c.peers[username]
+	return p, ok
+}
+
+// sendMessages delivers the outbox whenever a message is queued or the
+// connection comes back, and retries periodically in between.
 func (c *ClientManager) sendMessages() {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
 	for {
-		writeMessage(c.Conn, c.user, c.CurrentRoom.id, <-c.egress)
+		select {
+		case <-c.outboxReady:
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		c.flushOutbox()
 	}
 }
 
-func (c *ClientManager) Close() {
-	deadline := time.Now().Add(1 * time.Second)
-	if err := c.Conn.WriteControl(
-		websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
-		deadline,
-	); err != nil {
-		if err := c.Conn.Close(); err != nil {
-			log.Fatalf("Error while closing the websocket connection: %s", err)
+// flushOutbox sends the queued messages in order. It stops at the first
+// message that cannot be written, so the order is kept on the next try.
+func (c *ClientManager) flushOutbox() {
+	for {
+		entry, ok := c.outbox.next()
+		if !ok || c.currentConn() == nil {
+			return
+		}
+		msg := entry.Message
+
+		payloads, err := c.encrypt(eventSendMessage, entry.Room, msg.ID, msg.Content)
+		if err != nil {
+			log.Printf("could not encrypt the message: %s", err)
+			c.dropFromOutbox(msg.ID, err)
+			continue
 		}
+
+		if err := c.writeMessage(entry.Room, msg, payloads); err != nil {
+			log.Printf("could not send the message: %s", err)
+			attempts, saveErr := c.outbox.failedAttempt(msg.ID)
+			if saveErr != nil {
+				log.Printf("could not update the outbox: %s", saveErr)
+			}
+			if attempts >= maxSendAttempts {
+				c.dropFromOutbox(msg.ID, err)
+			}
+			return
+		}
+
+		// the entry stays queued until the server acks it
+		c.outbox.markInFlight(msg.ID)
+	}
+}
+
+func (c *ClientManager) dropFromOutbox(id uuid.UUID, cause error) {
+	if _, err := c.outbox.remove(id); err != nil {
+		log.Printf("could not update the outbox: %s", err)
+	}
+	c.notify(MessageStatusChanged{ID: id, Status: Failed, Err: cause})
+}
+
+// acknowledge removes a message stored by the server from the outbox.
+func (c *ClientManager) acknowledge(id uuid.UUID) {
+	removed, err := c.outbox.remove(id)
+	if err != nil {
+		log.Printf("could not update the outbox: %s", err)
+	}
+	if removed {
+		c.notify(MessageStatusChanged{ID: id, Status: Sent})
 	}
+}
+
+// wakeOutbox asks sendMessages to flush the outbox without blocking.
+func (c *ClientManager) wakeOutbox() {
+	select {
+	case c.outboxReady <- struct{}{}:
+	default:
+	}
+}
 
-	// Set deadline for reading the next message
-	if err := c.Conn.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
-		if err := c.Conn.Close(); err != nil {
-			log.Fatalf("Error while closing the websocket connection: %s", err)
+// encrypt seals the message once per recipient of the room, advancing the
+// ratchet session with each of them. Direct rooms only reach their peer,
+// other rooms the known peers among their members, or every known peer
+// when the members are unknown. A copy is always sealed for ourselves, so a
+// room without known peers still has a recipient. The server only relays
+// the ciphertexts. The payloads are bound to the kind of event and to the
+// message id, so the server cannot replay them as another event.
+func (c *ClientManager) encrypt(kind string, room, id uuid.UUID, msg string) (map[string][]byte, error) {
+	r := c.room(room)
+	members := r.Members()
+
+	c.peersMu.RLock()
+	defer c.peersMu.RUnlock()
+
+	recipients := make(map[string]peerInfo)
+	for name, p := range c.peers {
+		if len(members) == 0 || slices.Contains(members, name) {
+			recipients[name] = p
 		}
 	}
-	// Read messages until the close message is confirmed
-	for {
-		_, _, err := c.Conn.NextReader()
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			break
+	// a direct conversation is pointless without its member
+	if len(recipients) == 0 && r.Direct() {
+		return nil, errNoRecipients
+	}
+
+	ad := additionalData(kind, room, id)
+	payloads := make(map[string][]byte, len(recipients)+1)
+	for name, p := range recipients {
+		ciphertext, err := c.sessions.Encrypt(name, p.key, []byte(msg), ad)
+		if err != nil {
+			return nil, fmt.Errorf("could not encrypt for %s: %w", name, err)
 		}
+		payloads[name] = ciphertext
+	}
+	self, err := c.sessions.Identity().SealForSelf([]byte(msg), ad)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt our own copy: %w", err)
+	}
+	payloads[c.user.name] = self
+	return payloads, nil
+}
+
+// decrypt opens our payload of event. Only the sender could seal it, so a
+// payload that opens authenticates the sender and the event.
+func (c *ClientManager) decrypt(event Event) (string, error) {
+	ciphertext, ok := event.Payloads[c.user.name]
+	if !ok {
+		return "", errors.New("the message is not addressed to us")
+	}
+	ad := additionalData(event.Type, event.Room, event.Message.ID)
+	// the copy of our own messages is sealed without a session
+	if event.Message.Sender == c.user.name {
+		plaintext, err := c.sessions.Identity().OpenFromSelf(ciphertext, ad)
 		if err != nil {
-			break
+			return "", err
+		}
+		return string(plaintext), nil
+	}
+	p, ok := c.peer(event.Message.Sender)
+	if !ok {
+		return "", fmt.Errorf("unknown public key for %s", event.Message.Sender)
+	}
+	plaintext, err := c.sessions.Decrypt(event.Message.Sender, p.key, ciphertext, ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// additionalData is what a payload is bound to besides its content.
+func additionalData(kind string, room, id uuid.UUID) []byte {
+	return slices.Concat(room[:], id[:], []byte(kind))
+}
+
+// Close performs the closing handshake and stops reconnecting.
+func (c *ClientManager) Close() {
+	c.StopTyping()
+	c.closeOnce.Do(func() { close(c.done) })
+
+	conn := c.currentConn()
+	if conn == nil {
+		return
+	}
+	deadline := time.Now().Add(1 * time.Second)
+	if err := conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		deadline,
+	); err == nil {
+		// readMessages returns once the server confirms the closure
+		select {
+		case <-c.stopped:
+		case <-time.After(time.Until(deadline)):
 		}
 	}
+	if err := conn.Close(); err != nil {
+		log.Printf("Error while closing the websocket connection: %s", err)
+	}
 }
 
-func (c *ClientManager) readMessages() {
+// readMessages reads from conn until it fails, the error is returned so the
+// supervisor can decide whether to reconnect.
+func (c *ClientManager) readMessages(conn WebsocketConnection) error {
 	for {
 		var event Event
-		_, payload, err := c.Conn.ReadMessage()
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			log.Println("normal closure of the websocket connection...")
-			return
-		} else if err != nil {
-			log.Fatal(err)
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return err
 		}
 
 		if err := json.Unmarshal(payload, &event); err != nil {
 			log.Printf("error unmarshaling event: %v", err)
-			return
+			continue
+		}
+		switch event.Type {
+		case eventAck:
+			c.acknowledge(event.Message.ID)
+		case eventDelivered:
+			c.notify(MessageStatusChanged{ID: event.Message.ID, Status: Delivered})
+		case eventRead:
+			c.notify(MessageStatusChanged{ID: event.Message.ID, Status: Read})
+		case eventContactsChanged:
+			c.notify(ContactsChanged{})
+		case eventEditMessage:
+			content, err := c.decrypt(event)
+			if err != nil {
+				log.Printf("could not decrypt the edit from %s: %v", event.Message.Sender, err)
+				continue
+			}
+			c.notify(MessageEdited{ID: event.Message.ID, Room: event.Room, Sender: event.Message.Sender, Content: content})
+		case eventDeleteMessage:
+			// the payload is empty, it only proves who asked
+			if _, err := c.decrypt(event); err != nil {
+				log.Printf("could not authenticate the deletion from %s: %v", event.Message.Sender, err)
+				continue
+			}
+			c.notify(MessageDeleted{ID: event.Message.ID, Room: event.Room, Sender: event.Message.Sender})
+		case eventPresence:
+			if event.Message.Sender != c.user.name {
+				c.notify(PresenceChanged{Presence: api.Presence{
+					Username: event.Message.Sender,
+					Status:   event.Status,
+					Message:  event.StatusMessage,
+				}})
+			}
+		case eventTyping, eventStoppedTyping:
+			if event.Message.Sender != c.user.name {
+				c.notify(TypingChanged{Room: event.Room, User: event.Message.Sender, Typing: event.Type == eventTyping})
+			}
+		case eventRoomInfo:
+			c.addRoom(Room{id: event.Room})
+			c.updateRoom(event.Room, event.RoomName, event.Members)
+			c.notify(RoomsChanged{})
+		case eventSendMessage:
+			// our own copy echoed by the server, we have the message already
+			if event.Message.Sender == c.user.name {
+				continue
+			}
+			content, err := c.decrypt(event)
+			if err != nil {
+				log.Printf("could not decrypt the message from %s: %v", event.Message.Sender, err)
+				continue
+			}
+			// a message can open a room we did not know about yet
+			if _, added := c.addRoom(c.incomingRoom(event)); added {
+				c.notify(RoomsChanged{})
+			}
+			message := Message{
+				ID:      event.Message.ID,
+				Content: content,
+				Sender:  event.Message.Sender,
+				Date:    event.Message.Date,
+				Room:    event.Room,
+			}
+			c.sendReceipt(eventDelivered, message)
+			select {
+			case c.msgChan <- message:
+			case <-c.done:
+				return nil
+			}
+		default:
+			log.Printf("unknown event type: %s", event.Type)
 		}
-		// for now there are no rooms
-		// we assign the incoming event to the message object
-		message := Message{Content: string(event.Message.Content), Sender: event.Message.Sender}
-		c.msgChan <- message
 	}
 }
 
-func writeMessage(c WebsocketConnection, user userInfo, room uuid.UUID, message string) {
-	payload := getMessageToSend(room, user, message)
-	err := c.WriteMessage(websocket.TextMessage, payload)
+// MarkRead tells the sender of msg that the user has seen it.
+func (c *ClientManager) MarkRead(msg Message) {
+	c.sendReceipt(eventRead, msg)
+}
+
+// sendReceipt is best effort, a receipt lost while offline is not retried.
+func (c *ClientManager) sendReceipt(kind string, msg Message) {
+	data, err := json.Marshal(Event{
+		Type:    kind,
+		Room:    msg.Room,
+		Message: Message{ID: msg.ID, Sender: c.user.name},
+		To:      msg.Sender,
+	})
 	if err != nil {
-		log.Println("something went wrong while writing the message!!!!")
-		log.Fatal(err)
+		log.Printf("could not marshal the %s receipt: %s", kind, err)
+		return
+	}
+	if err := c.write(data); err != nil {
+		log.Printf("could not send the %s receipt: %s", kind, err)
+	}
+}
+
+func (c *ClientManager) writeMessage(room uuid.UUID, msg Message, payloads map[string][]byte) error {
+	return c.write(getMessageToSend(c.room(room), msg, payloads))
+}
+
+// write sends data over the current connection, gorilla only supports one
+// concurrent writer. A failed write leaves the connection unusable, it is
+// closed so the supervisor reconnects.
+func (c *ClientManager) write(data []byte) error {
+	conn := c.currentConn()
+	if conn == nil {
+		return errOffline
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
 	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *ClientManager) currentConn() WebsocketConnection {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn
 }
 
+func (c *ClientManager) setConn(conn WebsocketConnection) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.conn = conn
+}
+
+// MessageChannel delivers the incoming messages, it is closed once the
+// client stopped after Close.
 func (c *ClientManager) MessageChannel() <-chan Message {
 	return c.msgChan
 }
 
-func (c *ClientManager) SetEgress(msg string) {
-	c.egress <- msg
+// SetEgress queues the message for the current room and returns it with
+// its client generated ID, the delivery is reported via notifications.
+func (c *ClientManager) SetEgress(content string) Message {
+	msg := Message{
+		ID:      uuid.New(),
+		Sender:  c.user.name,
+		Content: content,
+		Date:    time.Now(),
+		Room:    c.Current().id,
+		Status:  Pending,
+	}
+	if err := c.outbox.push(msg.Room, msg); err != nil {
+		log.Printf("could not queue the message: %s", err)
+		msg.Status = Failed
+		return msg
+	}
+	c.wakeOutbox()
+	return msg
+}
+
+// PendingMessages returns the messages still waiting in the outbox.
+func (c *ClientManager) PendingMessages() []Message {
+	return c.outbox.Pending()
+}
+
+// Notifications delivers connection updates meant for the UI, it is closed
+// once the client stopped after Close.
+func (c *ClientManager) Notifications() <-chan Notification {
+	return c.notifications
+}
+
+func (c *ClientManager) notify(n Notification) {
+	select {
+	case c.notifications <- n:
+	case <-c.done:
+	}
+}
+
+// DecryptHistory decrypts the messages fetched from the server history,
+// leaving out the ones known already. A message is decrypted only once, so
+// the ones received before and not known by the caller cannot be read
+// again and are dropped as well. Our own messages open from their copy.
+func (c *ClientManager) DecryptHistory(events []json.RawMessage, known func(uuid.UUID) bool) []Message {
+	messages := []Message{}
+	for _, data := range events {
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("malformed message in the room history: %v", err)
+			continue
+		}
+		if event.Type != eventSendMessage || known(event.Message.ID) {
+			continue
+		}
+		content, err := c.decrypt(event)
+		if err != nil {
+			continue
+		}
+		message := Message{
+			ID:      event.Message.ID,
+			Content: content,
+			Sender:  event.Message.Sender,
+			Date:    event.Message.Date,
+			Room:    event.Room,
+		}
+		// the server stored it, so our own message was sent
+		if message.Sender == c.user.name {
+			message.Status = Sent
+		}
+		messages = append(messages, message)
+	}
+	return messages
 }
@@ -0,0 +1,205 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// testKey stands for the key of the local data.
+var testKey = bytes.Repeat([]byte{3}, 32)
+
+var testRoom = Room{id: uuid.MustParse("0c7e1d52-6f4a-4e2b-8d3c-5a9b7e6f1d20"), name: "general"}
+
+// fakeConn is a websocket connection that reads what the test sends and
+// records the events written.
+type fakeConn struct {
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	written  []Event
+	writeErr error
+	deadline time.Time
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{incoming: make(chan []byte), closed: make(chan struct{})}
+}
+
+func (f *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-f.incoming:
+		return websocket.TextMessage, data, nil
+	case <-f.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (f *fakeConn) WriteMessage(_ int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	f.written = append(f.written, event)
+	return nil
+}
+
+func (f *fakeConn) WriteControl(int, []byte, time.Time) error { return nil }
+func (f *fakeConn) SetReadDeadline(time.Time) error          { return nil }
+func (f *fakeConn) SetPongHandler(func(string) error)        {}
+
+func (f *fakeConn) SetWriteDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deadline = t
+	return nil
+}
+
+func (f *fakeConn) NextReader() (int, io.Reader, error) {
+	return 0, nil, errors.New("not supported by the fake connection")
+}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeConn) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *fakeConn) failWrites(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeErr = err
+}
+
+// events returns the events written so far and forgets them.
+func (f *fakeConn) events() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	events := f.written
+	f.written = nil
+	return events
+}
+
+// testClient is the client of a user connected to a fake connection,
+// without the supervisor and the outbox sender.
+type testClient struct {
+	*ClientManager
+	conn     *fakeConn
+	identity *e2e.Identity
+}
+
+func newTestClient(t *testing.T, name string, rooms ...Room) *testClient {
+	t.Helper()
+	dir := t.TempDir()
+	identity, err := e2e.NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := e2e.OpenSessions(filepath.Join(dir, "sessions.json"), testKey, identity, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := OpenOutbox(filepath.Join(dir, "outbox.json"), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) == 0 {
+		rooms = []Room{testRoom}
+	}
+
+	userID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(name))
+	c := NewClientManager(nil, DefaultHeartbeat, name, userID, sessions, outbox, rooms)
+	conn := newFakeConn()
+	c.setConn(conn)
+	t.Cleanup(func() {
+		c.closeOnce.Do(func() { close(c.done) })
+		conn.Close()
+	})
+	return &testClient{ClientManager: c, conn: conn, identity: identity}
+}
+
+// listen reads the events the test sends until it ends.
+func (c *testClient) listen() {
+	go c.readMessages(c.conn)
+}
+
+// introduce makes the users known peers of each other.
+func introduce(users ...*testClient) {
+	for _, a := range users {
+		for _, b := range users {
+			if a != b {
+				a.AddPeer(b.user.name, b.user.id, b.identity.PublicKey())
+			}
+		}
+	}
+}
+
+// receive hands event to the client as if the server relayed it.
+func (c *testClient) receive(t *testing.T, event Event) {
+	t.Helper()
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c.conn.incoming <- data:
+	case <-time.After(time.Second):
+		t.Fatal("the client does not read the connection")
+	}
+}
+
+func (c *testClient) nextNotification(t *testing.T) Notification {
+	t.Helper()
+	select {
+	case n := <-c.notifications:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+		return nil
+	}
+}
+
+// expectDropped checks the events received so far were not reported, by
+// sending another one that is reported right away.
+func (c *testClient) expectDropped(t *testing.T) {
+	t.Helper()
+	c.receive(t, Event{Type: eventContactsChanged})
+	if n := c.nextNotification(t); n != (ContactsChanged{}) {
+		t.Fatalf("got %#v, want the event dropped", n)
+	}
+}
+
+// lastEvent returns the only event written since the previous call.
+func (c *testClient) lastEvent(t *testing.T) Event {
+	t.Helper()
+	events := c.conn.events()
+	if len(events) != 1 {
+		t.Fatalf("%d events written, want 1: %+v", len(events), events)
+	}
+	return events[0]
+}
@@ -1,43 +1,80 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/CTSDM/motbwa-tui/internal/api"
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-func CreateConnection(s api.State) (*ClientManager, error) {
+const refreshTimeout = 10 * time.Second
+
+// CreateConnection starts a supervised connection to the websocket server,
+// joining the given rooms or a new default one when there are none. The
+// connection state is reported through the client notifications.
+func CreateConnection(s *api.State, sessions *e2e.Sessions, outbox *Outbox, heartbeat Heartbeat, rooms []Room) *ClientManager {
+	dialer := func() (WebsocketConnection, error) {
+		return dial(s)
+	}
+	if len(rooms) == 0 {
+		rooms = []Room{NewRoom("default")}
+	}
+	client := NewClientManager(dialer, heartbeat, s.User.Username, s.User.UserID, sessions, outbox, rooms)
+
+	client.start()
+
+	return client
+}
+
+// dial performs the websocket handshake. When the server rejects the access
+// token, the tokens are refreshed and the handshake is tried once more.
+func dial(s *api.State) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = s.TLS
+
+	token := s.AccessToken()
 	header := make(http.Header)
 	s.AddAuthTokensToHeader(&header)
 
-	conn, _, err := websocket.DefaultDialer.Dial(s.Server.WebsocketURL, header)
-	if err != nil {
-		log.Printf("couldnt perform the handshake on %s: %s", s.Server.WebsocketURL, err)
-		return nil, err
+	conn, res, err := dialer.Dial(s.Server.WebsocketURL, header)
+	if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		return conn, err
 	}
 
-	client := NewClientManager(conn, s.User.Username, s.User.UserID, Room{id: uuid.New(), name: "default"})
-
-	go client.readMessages()
-	go client.sendMessages()
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	if err := s.RefreshTokens(ctx, token); err != nil {
+		return nil, err
+	}
 
-	return client, nil
+	header = make(http.Header)
+	s.AddAuthTokensToHeader(&header)
+	conn, _, err = dialer.Dial(s.Server.WebsocketURL, header)
+	return conn, err
 }
 
-func getMessageToSend(room uuid.UUID, user userInfo, msg string) []byte {
+// getMessageToSend builds the event for an outgoing message. The content is
+// only carried inside the per recipient payloads, never in plaintext.
+// Messages of direct rooms are addressed to the other member only.
+func getMessageToSend(room Room, msg Message, payloads map[string][]byte) []byte {
 	event := Event{
-		Type: "send_message",
-		Room: room,
+		Type: eventSendMessage,
+		Room: room.id,
 		Message: Message{
-			Sender:  user.name,
-			Date:    time.Now(),
-			Content: msg,
+			ID:     msg.ID,
+			Sender: msg.Sender,
+			Date:   msg.Date,
 		},
+		Payloads: payloads,
+	}
+	if room.Direct() {
+		event.To = room.peer
 	}
 
 	data, err := json.Marshal(event)
@@ -47,8 +84,46 @@ func getMessageToSend(room uuid.UUID, user userInfo, msg string) []byte {
 	return data
 }
 
+// Event types of the websocket protocol. Acks come from the server once it
+// stored a message, receipts are relayed from the recipients.
+const (
+	eventSendMessage = "send_message"
+	eventCreateRoom  = "create_room"
+	eventJoinRoom    = "join_room"
+	eventLeaveRoom   = "leave_room"
+	eventRoomInfo    = "room_info"
+	eventAck         = "ack"
+	eventDelivered   = "delivered"
+	eventRead        = "read"
+
+	// eventTyping is repeated while a user types in a room, until an
+	// eventStoppedTyping.
+	eventTyping        = "typing"
+	eventStoppedTyping = "stopped_typing"
+
+	// eventEditMessage and eventDeleteMessage change a message sent
+	// before, they carry its ID.
+	eventEditMessage   = "edit_message"
+	eventDeleteMessage = "delete_message"
+
+	// eventPresence announces the status of a user to their contacts.
+	eventPresence = "presence"
+
+	// eventContactsChanged is sent when another device of the same account
+	// edits the contact list.
+	eventContactsChanged = "contacts_changed"
+)
+
 type Event struct {
-	Type    string    `json:"type"`
-	Room    uuid.UUID `json:"room"`
-	Message Message   `json:"message"`
+	Type     string            `json:"type"`
+	Room     uuid.UUID         `json:"room"`
+	Message  Message           `json:"message"`
+	Payloads map[string][]byte `json:"payloads,omitempty"`
+	// To addresses the events meant for a single user, like receipts.
+	To       string   `json:"to,omitempty"`
+	RoomName string   `json:"room_name,omitempty"`
+	Members  []string `json:"members,omitempty"`
+	// Status and StatusMessage are the presence of the sender.
+	Status        api.PresenceStatus `json:"status,omitempty"`
+	StatusMessage string             `json:"status_message,omitempty"`
 }
@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var errNotSent = errors.New("only the messages already sent can be changed")
+
+// EditMessage replaces the content of msg, one of ours, for every recipient
+// of its room. Unlike new messages, changes are not queued while offline.
+func (c *ClientManager) EditMessage(msg Message, content string) error {
+	if err := c.changeable(msg); err != nil {
+		return err
+	}
+	return c.writeChange(eventEditMessage, msg, content)
+}
+
+// DeleteMessage asks the recipients of msg, one of ours, to drop it. The
+// request is encrypted like an edit without content, so the recipients
+// only drop it when we asked.
+func (c *ClientManager) DeleteMessage(msg Message) error {
+	if err := c.changeable(msg); err != nil {
+		return err
+	}
+	return c.writeChange(eventDeleteMessage, msg, "")
+}
+
+func (c *ClientManager) changeable(msg Message) error {
+	switch {
+	case msg.Sender != c.user.name:
+		return errors.New("only your own messages can be changed")
+	case msg.Deleted:
+		return errors.New("the message was deleted")
+	case msg.Status == Pending || msg.Status == Failed:
+		return errNotSent
+	}
+	return nil
+}
+
+func (c *ClientManager) writeChange(kind string, msg Message, content string) error {
+	// the payloads advance the ratchets, they must not be built for nothing
+	if c.currentConn() == nil {
+		return errOffline
+	}
+	payloads, err := c.encrypt(kind, msg.Room, msg.ID, content)
+	if err != nil {
+		return err
+	}
+	event := Event{
+		Type:     kind,
+		Room:     msg.Room,
+		Message:  Message{ID: msg.ID, Sender: c.user.name},
+		Payloads: payloads,
+	}
+	if room := c.room(msg.Room); room.Direct() {
+		event.To = room.peer
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func editMessage(c *testClient, msg Message) error { return c.EditMessage(msg, "edited") }
+func deleteMessage(c *testClient, msg Message) error { return c.DeleteMessage(msg) }
+
+func TestChangeReceived(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*testClient, Message) error
+		// tamper changes the event on its way through the server
+		tamper func(*Event)
+		want   func(Message) Notification
+	}{
+		{
+			name:   "edit",
+			change: editMessage,
+			want: func(msg Message) Notification {
+				return MessageEdited{ID: msg.ID, Room: msg.Room, Sender: "bob", Content: "edited"}
+			},
+		},
+		{
+			name:   "delete",
+			change: deleteMessage,
+			want: func(msg Message) Notification {
+				return MessageDeleted{ID: msg.ID, Room: msg.Room, Sender: "bob"}
+			},
+		},
+		{name: "delete without payload", change: deleteMessage, tamper: func(e *Event) { e.Payloads = nil }},
+		{name: "edit replayed as a deletion", change: editMessage, tamper: func(e *Event) { e.Type = eventDeleteMessage }},
+		{name: "edit of another message", change: editMessage, tamper: func(e *Event) { e.Message.ID = uuid.New() }},
+		{name: "edit in another room", change: editMessage, tamper: func(e *Event) { e.Room = uuid.New() }},
+		{name: "edit by another sender", change: editMessage, tamper: func(e *Event) { e.Message.Sender = "carol" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice, bob, carol := newTestClient(t, "alice"), newTestClient(t, "bob"), newTestClient(t, "carol")
+			introduce(alice, bob, carol)
+			alice.listen()
+
+			msg := Message{ID: uuid.New(), Sender: "bob", Content: "hello", Room: testRoom.id, Status: Sent}
+			if err := tt.change(bob, msg); err != nil {
+				t.Fatal(err)
+			}
+			event := bob.lastEvent(t)
+			if event.Message.Content != "" {
+				t.Fatal("the change was sent in the clear")
+			}
+			if tt.tamper != nil {
+				tt.tamper(&event)
+			}
+
+			alice.receive(t, event)
+			if tt.want == nil {
+				alice.expectDropped(t)
+				return
+			}
+			if n := alice.nextNotification(t); n != tt.want(msg) {
+				t.Fatalf("got %#v, want %#v", n, tt.want(msg))
+			}
+		})
+	}
+}
+
+func TestChangeRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		offline bool
+		err     error
+	}{
+		{name: "pending", msg: Message{Sender: "alice", Status: Pending}, err: errNotSent},
+		{name: "failed", msg: Message{Sender: "alice", Status: Failed}, err: errNotSent},
+		{name: "offline", msg: Message{Sender: "alice", Status: Sent}, offline: true, err: errOffline},
+		{name: "not ours", msg: Message{Sender: "bob", Status: Sent}},
+		{name: "deleted", msg: Message{Sender: "alice", Status: Sent, Deleted: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := newTestClient(t, "alice")
+			if tt.offline {
+				alice.setConn(nil)
+			}
+			tt.msg.ID, tt.msg.Room = uuid.New(), testRoom.id
+			for _, change := range []func(*testClient, Message) error{editMessage, deleteMessage} {
+				err := change(alice, tt.msg)
+				if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+			}
+			if events := alice.conn.events(); len(events) != 0 {
+				t.Fatalf("got %+v, want nothing sent", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const pingWriteWait = 5 * time.Second
+
+// Heartbeat configures the keepalive of the connection. A ping is sent
+// every Interval and the connection is considered dead once MaxMissed
+// pings in a row went unanswered.
+type Heartbeat struct {
+	Interval  time.Duration
+	MaxMissed int
+}
+
+var DefaultHeartbeat = Heartbeat{Interval: 5 * time.Second, MaxMissed: 2}
+
+// readTimeout is how long we wait for a pong before giving up the connection.
+func (h Heartbeat) readTimeout() time.Duration {
+	return h.Interval * time.Duration(h.MaxMissed+1)
+}
+
+// startHeartbeat arms the read deadline of conn, extends it on every pong
+// and pings the server until stop is closed. A dead connection makes the
+// pending read fail, which triggers the reconnection.
+func (c *ClientManager) startHeartbeat(conn WebsocketConnection, stop <-chan struct{}) error {
+	timeout := c.heartbeat.readTimeout()
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	go func() {
+		ticker := time.NewTicker(c.heartbeat.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteWait)); err != nil {
+					log.Printf("could not ping the websocket server: %s", err)
+					conn.Close()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
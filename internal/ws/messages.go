@@ -1,9 +1,104 @@
 package ws
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Message struct {
+	ID      uuid.UUID
 	Sender  string
 	Content string
 	Date    time.Time
+	// Room, Status and the edit markers are known locally, they never go
+	// on the wire.
+	Room   uuid.UUID      `json:"-"`
+	Status DeliveryStatus `json:"-"`
+	Edited bool           `json:"-"`
+	// Deleted marks a tombstone, the content is gone.
+	Deleted bool `json:"-"`
+}
+
+type DeliveryStatus int
+
+const (
+	// Received marks the messages written by other users.
+	Received DeliveryStatus = iota
+	Pending
+	// Sent means the server acknowledged the message.
+	Sent
+	Delivered
+	Read
+	Failed
+)
+
+// Advances reports whether moving from s to next is progress, a receipt
+// arriving late must not downgrade a message already marked as read.
+func (s DeliveryStatus) Advances(next DeliveryStatus) bool {
+	return next == Failed || next > s
+}
+
+// Notification is an update about the client meant for the UI.
+type Notification interface {
+	notification()
 }
+
+type ConnectionStatus int
+
+const (
+	Connecting ConnectionStatus = iota
+	Online
+	Offline
+)
+
+func (s ConnectionStatus) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Online:
+		return "online"
+	case Offline:
+		return "offline"
+	}
+	return "unknown"
+}
+
+type ConnectionStatusChanged struct {
+	Status ConnectionStatus
+	Err    error
+}
+
+func (ConnectionStatusChanged) notification() {}
+
+type MessageStatusChanged struct {
+	ID     uuid.UUID
+	Status DeliveryStatus
+	Err    error
+}
+
+func (MessageStatusChanged) notification() {}
+
+// MessageEdited reports that the sender of a message replaced its content.
+type MessageEdited struct {
+	ID      uuid.UUID
+	Room    uuid.UUID
+	Sender  string
+	Content string
+}
+
+func (MessageEdited) notification() {}
+
+// MessageDeleted reports that the sender of a message deleted it.
+type MessageDeleted struct {
+	ID     uuid.UUID
+	Room   uuid.UUID
+	Sender string
+}
+
+func (MessageDeleted) notification() {}
+
+// ContactsChanged asks the UI to reload the contact list from the server.
+type ContactsChanged struct{}
+
+func (ContactsChanged) notification() {}
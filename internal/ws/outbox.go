@@ -0,0 +1,136 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
+
+	"github.com/CTSDM/motbwa-tui/internal/e2e"
+	"github.com/google/uuid"
+)
+
+type outboxEntry struct {
+	Room     uuid.UUID `json:"room"`
+	Message  Message   `json:"message"`
+	Attempts int       `json:"attempts"`
+	// InFlight entries were written and wait for the server ack.
+	InFlight bool `json:"-"`
+}
+
+// Outbox keeps on disk the messages that were not delivered yet, so they
+// survive disconnections and restarts. They are not encrypted for their
+// recipients yet, the file is encrypted with the key of the local data.
+type Outbox struct {
+	mu      sync.Mutex
+	path    string
+	key     []byte
+	entries []outboxEntry
+}
+
+func OpenOutbox(path string, key []byte) (*Outbox, error) {
+	o := &Outbox{path: path, key: key}
+
+	data, err := e2e.OpenFile(path, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, fmt.Errorf("could not parse the outbox %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// Pending returns the queued messages in the order they will be sent.
+func (o *Outbox) Pending() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	messages := make([]Message, 0, len(o.entries))
+	for _, entry := range o.entries {
+		msg := entry.Message
+		msg.Room = entry.Room
+		msg.Status = Pending
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func (o *Outbox) push(room uuid.UUID, msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = append(o.entries, outboxEntry{Room: room, Message: msg})
+	return o.save()
+}
+
+// next returns the oldest entry that was not written yet.
+func (o *Outbox) next() (outboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, entry := range o.entries {
+		if !entry.InFlight {
+			return entry, true
+		}
+	}
+	return outboxEntry{}, false
+}
+
+func (o *Outbox) markInFlight(id uuid.UUID) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.entries {
+		if o.entries[i].Message.ID == id {
+			o.entries[i].InFlight = true
+			return
+		}
+	}
+}
+
+// resetInFlight makes every entry eligible to be written again. The server
+// ignores the IDs it already stored.
+func (o *Outbox) resetInFlight() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.entries {
+		o.entries[i].InFlight = false
+	}
+}
+
+// remove drops the entry with id and reports whether it was queued.
+func (o *Outbox) remove(id uuid.UUID) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(o.entries)
+	o.entries = slices.DeleteFunc(o.entries, func(e outboxEntry) bool {
+		return e.Message.ID == id
+	})
+	if len(o.entries) == n {
+		return false, nil
+	}
+	return true, o.save()
+}
+
+// failedAttempt records a failed delivery and returns the attempts so far.
+func (o *Outbox) failedAttempt(id uuid.UUID) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.entries {
+		if o.entries[i].Message.ID == id {
+			o.entries[i].Attempts++
+			return o.entries[i].Attempts, o.save()
+		}
+	}
+	return 0, nil
+}
+
+func (o *Outbox) save() error {
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		return err
+	}
+	return e2e.SealFile(o.path, o.key, data)
+}
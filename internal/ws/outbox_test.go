@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func openTestOutbox(t *testing.T, path string) *Outbox {
+	t.Helper()
+	o, err := OpenOutbox(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func queued(id uuid.UUID, content string) Message {
+	return Message{ID: id, Sender: "alice", Content: content, Room: testRoom.id}
+}
+
+func TestOutboxPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	first, second := uuid.New(), uuid.New()
+
+	o := openTestOutbox(t, path)
+	for _, msg := range []Message{queued(first, "first secret"), queued(second, "second secret")} {
+		if err := o.push(testRoom.id, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := o.failedAttempt(second); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := o.remove(first); err != nil || !removed {
+		t.Fatalf("removed %v, %v", removed, err)
+	}
+
+	o = openTestOutbox(t, path)
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].ID != second || pending[0].Content != "second secret" || pending[0].Status != Pending {
+		t.Fatalf("got %+v after reopening, want the second message pending", pending)
+	}
+	if o.entries[0].Attempts != 1 {
+		t.Fatalf("%d attempts after reopening, want 1", o.entries[0].Attempts)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("the outbox was saved in the clear")
+	}
+	if _, err := OpenOutbox(path, bytes.Repeat([]byte{4}, 32)); err == nil {
+		t.Fatal("the outbox was opened with another key")
+	}
+}
+
+func TestOutboxSendAndAck(t *testing.T) {
+	alice := newTestClient(t, "alice")
+	alice.listen()
+	msg := alice.SetEgress("hello")
+
+	alice.flushOutbox()
+	sent := alice.lastEvent(t)
+	if sent.Type != eventSendMessage || sent.Message.ID != msg.ID || sent.Message.Content != "" {
+		t.Fatalf("got %+v, want the message without its content", sent)
+	}
+	if _, ok := sent.Payloads["alice"]; !ok {
+		t.Fatal("no copy sealed for ourselves")
+	}
+
+	// the message waits for its ack, unless the connection was replaced
+	alice.flushOutbox()
+	if events := alice.conn.events(); len(events) != 0 {
+		t.Fatalf("%d events written while waiting for the ack", len(events))
+	}
+	alice.outbox.resetInFlight()
+	alice.flushOutbox()
+	if again := alice.lastEvent(t); again.Message.ID != msg.ID {
+		t.Fatalf("got %+v, want the message sent again", again)
+	}
+
+	alice.receive(t, Event{Type: eventAck, Message: Message{ID: msg.ID}})
+	if n := alice.nextNotification(t); n != (MessageStatusChanged{ID: msg.ID, Status: Sent}) {
+		t.Fatalf("got %#v, want the message sent", n)
+	}
+	if pending := alice.PendingMessages(); len(pending) != 0 {
+		t.Fatalf("%d messages left in the outbox after the ack", len(pending))
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	errWrite := errors.New("broken pipe")
+	tests := []struct {
+		name     string
+		failures int
+		status   DeliveryStatus
+	}{
+		{name: "recovers", failures: maxSendAttempts - 1, status: Sent},
+		{name: "gives up", failures: maxSendAttempts, status: Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := newTestClient(t, "alice")
+			alice.listen()
+			msg := alice.SetEgress("hello")
+
+			for range tt.failures {
+				// every failed write drops the connection, a new one is
+				// dialed in between
+				conn := newFakeConn()
+				conn.failWrites(errWrite)
+				alice.setConn(conn)
+				alice.flushOutbox()
+			}
+			alice.setConn(alice.conn)
+			alice.flushOutbox()
+
+			if tt.status == Failed {
+				n := alice.nextNotification(t).(MessageStatusChanged)
+				if n.ID != msg.ID || n.Status != Failed || !errors.Is(n.Err, errWrite) {
+					t.Fatalf("got %#v, want the message failed", n)
+				}
+				if len(alice.PendingMessages()) != 0 || len(alice.conn.events()) != 0 {
+					t.Fatal("the message was kept after the last attempt")
+				}
+				return
+			}
+			alice.lastEvent(t)
+			alice.receive(t, Event{Type: eventAck, Message: Message{ID: msg.ID}})
+			if n := alice.nextNotification(t); n != (MessageStatusChanged{ID: msg.ID, Status: Sent}) {
+				t.Fatalf("got %#v, want the message sent", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+)
+
+// PresenceChanged reports the presence a contact announced. The server
+// announces the offline status when their last connection drops.
+type PresenceChanged struct {
+	Presence api.Presence
+}
+
+func (PresenceChanged) notification() {}
+
+// SetPresence announces the status of the user to their contacts, it is
+// announced again after every reconnection.
+func (c *ClientManager) SetPresence(status api.PresenceStatus, message string) {
+	c.presenceMu.Lock()
+	c.presence = api.Presence{Username: c.user.name, Status: status, Message: message}
+	c.presenceMu.Unlock()
+	c.sendPresence()
+}
+
+// sendPresence is best effort, the presence is sent again on reconnection.
+func (c *ClientManager) sendPresence() {
+	c.presenceMu.RLock()
+	presence := c.presence
+	c.presenceMu.RUnlock()
+	// until it is set, the server reports the user online while connected
+	if presence.Status == "" {
+		return
+	}
+
+	data, err := json.Marshal(Event{
+		Type:          eventPresence,
+		Message:       Message{Sender: c.user.name},
+		Status:        presence.Status,
+		StatusMessage: presence.Message,
+	})
+	if err != nil {
+		log.Printf("could not marshal the presence: %s", err)
+		return
+	}
+	if err := c.write(data); err != nil && !errors.Is(err, errOffline) {
+		log.Printf("could not send the presence: %s", err)
+	}
+}
@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/CTSDM/motbwa-tui/internal/api"
+)
+
+func TestPresenceSent(t *testing.T) {
+	alice := newTestClient(t, "alice")
+
+	alice.SetPresence(api.PresenceAway, "lunch")
+	event := alice.lastEvent(t)
+	if event.Type != eventPresence || event.Message.Sender != "alice" || event.Status != api.PresenceAway || event.StatusMessage != "lunch" {
+		t.Fatalf("got %+v, want away with its message", event)
+	}
+
+	// set while offline, it is announced once connected
+	conn := alice.conn
+	alice.setConn(nil)
+	alice.SetPresence(api.PresenceOnline, "")
+	alice.setConn(conn)
+	alice.sendPresence()
+	if event := alice.lastEvent(t); event.Status != api.PresenceOnline || event.StatusMessage != "" {
+		t.Fatalf("got %+v, want the presence set while offline", event)
+	}
+}
+
+func TestPresenceNotSetNotSent(t *testing.T) {
+	alice := newTestClient(t, "alice")
+	alice.sendPresence()
+	if events := alice.conn.events(); len(events) != 0 {
+		t.Fatalf("got %+v, want nothing announced before a presence was set", events)
+	}
+}
+
+func TestPresenceReceived(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  Notification
+	}{
+		{
+			name:  "contact",
+			event: Event{Type: eventPresence, Message: Message{Sender: "bob"}, Status: api.PresenceAway, StatusMessage: "lunch"},
+			want:  PresenceChanged{Presence: api.Presence{Username: "bob", Status: api.PresenceAway, Message: "lunch"}},
+		},
+		{
+			name:  "offline",
+			event: Event{Type: eventPresence, Message: Message{Sender: "bob"}, Status: api.PresenceOffline},
+			want:  PresenceChanged{Presence: api.Presence{Username: "bob", Status: api.PresenceOffline}},
+		},
+		{
+			name:  "our own echo",
+			event: Event{Type: eventPresence, Message: Message{Sender: "alice"}, Status: api.PresenceAway},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := newTestClient(t, "alice")
+			alice.listen()
+			alice.receive(t, tt.event)
+			if tt.want == nil {
+				alice.expectDropped(t)
+				return
+			}
+			if n := alice.nextNotification(t); n != tt.want {
+				t.Fatalf("got %#v, want %#v", n, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var errLastRoom = errors.New("cannot leave the only room")
+
+// directRoomNamespace scopes the IDs of the one to one rooms.
+var directRoomNamespace = uuid.MustParse("5b0e4f36-3c55-4b8f-9a59-0b1c6e0f8d2a")
+
+type Room struct {
+	id   uuid.UUID
+	name string
+	// peer is the other side of a direct conversation, empty for the other
+	// rooms.
+	peer string
+	// members restricts the recipients of a room that is not direct, when
+	// the server reported them.
+	members []string
+}
+
+func NewRoom(name string) Room {
+	return Room{id: uuid.New(), name: name}
+}
+
+func (r Room) ID() uuid.UUID {
+	return r.id
+}
+
+// Members returns the other members of the room, nil when they are
+// unknown.
+func (r Room) Members() []string {
+	if r.Direct() {
+		return []string{r.peer}
+	}
+	return slices.Clone(r.members)
+}
+
+// Peer returns the other side of a direct room.
+func (r Room) Peer() string {
+	return r.peer
+}
+
+// Direct reports whether the room is a one to one conversation.
+func (r Room) Direct() bool {
+	return r.peer != ""
+}
+
+// DirectRoomID returns the ID of the conversation between two users, both
+// sides derive the same one.
+func DirectRoomID(a, b uuid.UUID) uuid.UUID {
+	if bytes.Compare(b[:], a[:]) < 0 {
+		a, b = b, a
+	}
+	return uuid.NewSHA1(directRoomNamespace, append(a[:], b[:]...))
+}
+
+func (r Room) Name() string {
+	if r.name == "" {
+		return r.id.String()[:8]
+	}
+	return r.name
+}
+
+// RoomState is the form of a Room that can be persisted.
+type RoomState struct {
+	ID      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	Peer    string    `json:"peer,omitempty"`
+	Members []string  `json:"members,omitempty"`
+}
+
+func (r Room) State() RoomState {
+	return RoomState{ID: r.id, Name: r.name, Peer: r.peer, Members: r.members}
+}
+
+// RestoreRoom rebuilds a persisted room.
+func RestoreRoom(state RoomState) Room {
+	return Room{id: state.ID, name: state.Name, peer: state.Peer, members: state.Members}
+}
+
+type RoomsChanged struct{}
+
+func (RoomsChanged) notification() {}
+
+// Current returns the room the messages are sent to.
+func (c *ClientManager) Current() Room {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+	return *c.CurrentRoom
+}
+
+// RoomList returns the joined rooms in the order they were joined.
+func (c *ClientManager) RoomList() []Room {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+	return slices.Clone(c.Rooms)
+}
+
+// CreateRoom creates a new room on the server and makes it current.
+func (c *ClientManager) CreateRoom(name string) (Room, error) {
+	room := NewRoom(name)
+	if err := c.sendRoomEvent(eventCreateRoom, room); err != nil {
+		return Room{}, err
+	}
+	c.addRoom(room)
+	c.SwitchRoom(room.id)
+	return room, nil
+}
+
+// JoinRoom subscribes to an existing room and makes it current. The server
+// answers with the room information, the name is unknown until then.
+func (c *ClientManager) JoinRoom(id uuid.UUID) (Room, error) {
+	room := Room{id: id}
+	if err := c.sendRoomEvent(eventJoinRoom, room); err != nil {
+		return Room{}, err
+	}
+	room, _ = c.addRoom(room)
+	c.SwitchRoom(id)
+	return room, nil
+}
+
+// OpenDirectRoom joins the conversation with peer and makes it current.
+func (c *ClientManager) OpenDirectRoom(peer string, peerID uuid.UUID) (Room, error) {
+	room := Room{id: DirectRoomID(c.user.id, peerID), name: "@" + peer, peer: peer}
+	if err := c.sendRoomEvent(eventJoinRoom, room); err != nil {
+		return Room{}, err
+	}
+	room, _ = c.addRoom(room)
+	c.SwitchRoom(room.id)
+	return room, nil
+}
+
+// incomingRoom returns the room a message arrived in, recognizing the
+// direct conversations opened by a contact.
+func (c *ClientManager) incomingRoom(event Event) Room {
+	if p, ok := c.peer(event.Message.Sender); ok && event.Room == DirectRoomID(c.user.id, p.id) {
+		sender := event.Message.Sender
+		return Room{id: event.Room, name: "@" + sender, peer: sender}
+	}
+	return Room{id: event.Room}
+}
+
+// room returns the joined room with id, or a room without restrictions
+// when it is unknown.
+func (c *ClientManager) room(id uuid.UUID) Room {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+	for _, r := range c.Rooms {
+		if r.id == id {
+			return r
+		}
+	}
+	return Room{id: id}
+}
+
+// LeaveRoom unsubscribes from the room. When it was the current one, the
+// first remaining room becomes current.
+func (c *ClientManager) LeaveRoom(id uuid.UUID) error {
+	c.roomsMu.RLock()
+	last := len(c.Rooms) <= 1
+	c.roomsMu.RUnlock()
+	if last {
+		return errLastRoom
+	}
+
+	if err := c.sendRoomEvent(eventLeaveRoom, Room{id: id}); err != nil {
+		return err
+	}
+
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	c.Rooms = slices.DeleteFunc(c.Rooms, func(r Room) bool { return r.id == id })
+	if c.CurrentRoom.id == id {
+		current := c.Rooms[0]
+		c.CurrentRoom = &current
+	}
+	return nil
+}
+
+// SwitchRoom makes the joined room with id current.
+func (c *ClientManager) SwitchRoom(id uuid.UUID) bool {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	for _, room := range c.Rooms {
+		if room.id == id {
+			c.CurrentRoom = &room
+			return true
+		}
+	}
+	return false
+}
+
+// addRoom adds the room unless it is already joined. It returns the known
+// version of the room and whether it was added.
+func (c *ClientManager) addRoom(room Room) (Room, bool) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	for _, r := range c.Rooms {
+		if r.id == room.id {
+			return r, false
+		}
+	}
+	c.Rooms = append(c.Rooms, room)
+	return room, true
+}
+
+// updateRoom applies the information the server reported for a joined
+// room. The members replace the known ones, so the members who joined
+// since are sent the next messages. The rooms keep their name when it
+// reported none.
+func (c *ClientManager) updateRoom(id uuid.UUID, name string, members []string) {
+	update := func(r *Room) {
+		if name != "" {
+			r.name = name
+		}
+		if !r.Direct() {
+			r.members = slices.Clone(members)
+		}
+	}
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	for i := range c.Rooms {
+		if c.Rooms[i].id == id {
+			update(&c.Rooms[i])
+		}
+	}
+	if c.CurrentRoom.id == id {
+		update(c.CurrentRoom)
+	}
+}
+
+func (c *ClientManager) sendRoomEvent(kind string, room Room) error {
+	data, err := json.Marshal(Event{Type: kind, Room: room.id, RoomName: room.name, Members: room.Members()})
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// supervise keeps the connection alive until Close is called, dialing
+// again with a jittered exponential backoff whenever it drops.
+func (c *ClientManager) supervise() {
+	defer close(c.stopped)
+
+	backoff := minBackoff
+	for {
+		c.notify(ConnectionStatusChanged{Status: Connecting})
+		conn, err := c.dial()
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			log.Printf("could not connect to the websocket server: %s", err)
+			c.notify(ConnectionStatusChanged{Status: Offline, Err: err})
+			if !c.wait(backoff) {
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		backoff = minBackoff
+		stopHeartbeat := make(chan struct{})
+		if err := c.startHeartbeat(conn, stopHeartbeat); err != nil {
+			log.Printf("could not start the heartbeat: %s", err)
+		}
+		c.setConn(conn)
+		if err := c.rejoinRooms(); err != nil {
+			log.Printf("could not join the rooms again: %s", err)
+		}
+		c.sendPresence()
+		c.notify(ConnectionStatusChanged{Status: Online})
+		// messages without ack may have been lost with the old connection
+		c.outbox.resetInFlight()
+		c.wakeOutbox()
+
+		err = c.readMessages(conn)
+		close(stopHeartbeat)
+		c.setConn(nil)
+		if c.isClosed() {
+			return
+		}
+		conn.Close()
+		log.Printf("lost the websocket connection: %s", err)
+		c.notify(ConnectionStatusChanged{Status: Offline, Err: err})
+	}
+}
+
+// wait sleeps for a random duration up to backoff. It returns false when
+// the client was closed in the meantime.
+func (c *ClientManager) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(rand.N(backoff) + minBackoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *ClientManager) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// rejoinRooms subscribes again to the rooms after a reconnection, the
+// server forgets them together with the previous connection.
+func (c *ClientManager) rejoinRooms() error {
+	joined := map[uuid.UUID]struct{}{}
+	rooms := append([]Room{c.Current()}, c.RoomList()...)
+	for _, room := range rooms {
+		if _, ok := joined[room.id]; ok {
+			continue
+		}
+		joined[room.id] = struct{}{}
+
+		if err := c.sendRoomEvent(eventJoinRoom, room); err != nil {
+			return err
+		}
+	}
+	return nil
+}
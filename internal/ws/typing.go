@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// typingInterval throttles the typing events sent while the user keeps
+	// typing.
+	typingInterval = 3 * time.Second
+	// typingIdle is how long after the last edit the user is considered to
+	// have stopped typing.
+	typingIdle = 5 * time.Second
+	// TypingTimeout is how long an indicator lasts without a new typing
+	// event, in case the stopped_typing one was lost.
+	TypingTimeout = 2 * typingInterval
+)
+
+// TypingChanged reports that a user started or stopped typing in a room.
+type TypingChanged struct {
+	Room   uuid.UUID
+	User   string
+	Typing bool
+}
+
+func (TypingChanged) notification() {}
+
+// typingState is what was last told to the room the user types in. sent is
+// zero when the room was told the user stopped.
+type typingState struct {
+	mu   sync.Mutex
+	room uuid.UUID
+	sent time.Time
+	idle *time.Timer
+}
+
+// Typing reports that the user edited the message of the current room. The
+// room is told at most once every typingInterval, and that the user stopped
+// once there was no edit for typingIdle.
+func (c *ClientManager) Typing() {
+	room := c.Current().id
+
+	c.typing.mu.Lock()
+	defer c.typing.mu.Unlock()
+	if !c.typing.sent.IsZero() && c.typing.room != room {
+		c.sendTyping(eventStoppedTyping, c.typing.room)
+		c.typing.sent = time.Time{}
+	}
+	if time.Since(c.typing.sent) >= typingInterval {
+		c.sendTyping(eventTyping, room)
+		c.typing.room = room
+		c.typing.sent = time.Now()
+	}
+
+	if c.typing.idle == nil {
+		c.typing.idle = time.AfterFunc(typingIdle, c.StopTyping)
+	} else {
+		c.typing.idle.Reset(typingIdle)
+	}
+}
+
+// StopTyping tells the room the user typed in that they stopped, when it
+// was told they were typing.
+func (c *ClientManager) StopTyping() {
+	c.typing.mu.Lock()
+	defer c.typing.mu.Unlock()
+	if c.typing.idle != nil {
+		c.typing.idle.Stop()
+	}
+	if c.typing.sent.IsZero() {
+		return
+	}
+	c.typing.sent = time.Time{}
+	c.sendTyping(eventStoppedTyping, c.typing.room)
+}
+
+// sendTyping is best effort like the receipts, the indicators of the peers
+// expire on their own.
+func (c *ClientManager) sendTyping(kind string, id uuid.UUID) {
+	event := Event{Type: kind, Room: id, Message: Message{Sender: c.user.name}}
+	if room := c.room(id); room.Direct() {
+		event.To = room.peer
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("could not marshal the %s event: %s", kind, err)
+		return
+	}
+	if err := c.write(data); err != nil && !errors.Is(err, errOffline) {
+		log.Printf("could not send the %s event: %s", kind, err)
+	}
+}
@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTypingSent(t *testing.T) {
+	direct := Room{id: uuid.New(), name: "@bob", peer: "bob"}
+	alice := newTestClient(t, "alice", direct, testRoom)
+
+	alice.Typing()
+	typing := alice.lastEvent(t)
+	if typing.Type != eventTyping || typing.Room != direct.id || typing.To != "bob" || typing.Message.Sender != "alice" {
+		t.Fatalf("got %+v, want typing addressed to bob", typing)
+	}
+	// the room is not told again before typingInterval
+	alice.Typing()
+	if events := alice.conn.events(); len(events) != 0 {
+		t.Fatalf("got %+v, want the typing events throttled", events)
+	}
+
+	// moving to another room stops the indicator of the previous one
+	alice.SwitchRoom(testRoom.id)
+	alice.Typing()
+	events := alice.conn.events()
+	if len(events) != 2 || events[0].Type != eventStoppedTyping || events[0].Room != direct.id ||
+		events[1].Type != eventTyping || events[1].Room != testRoom.id || events[1].To != "" {
+		t.Fatalf("got %+v, want stopped in the direct room then typing in the other", events)
+	}
+
+	alice.StopTyping()
+	if stopped := alice.lastEvent(t); stopped.Type != eventStoppedTyping || stopped.Room != testRoom.id {
+		t.Fatalf("got %+v, want stopped typing", stopped)
+	}
+	alice.StopTyping()
+	if events := alice.conn.events(); len(events) != 0 {
+		t.Fatalf("got %+v, want the room told only once", events)
+	}
+}
+
+func TestTypingReceived(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  Notification
+	}{
+		{
+			name:  "typing",
+			event: Event{Type: eventTyping, Room: testRoom.id, Message: Message{Sender: "bob"}},
+			want:  TypingChanged{Room: testRoom.id, User: "bob", Typing: true},
+		},
+		{
+			name:  "stopped",
+			event: Event{Type: eventStoppedTyping, Room: testRoom.id, Message: Message{Sender: "bob"}},
+			want:  TypingChanged{Room: testRoom.id, User: "bob", Typing: false},
+		},
+		{
+			name:  "our own echo",
+			event: Event{Type: eventTyping, Room: testRoom.id, Message: Message{Sender: "alice"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := newTestClient(t, "alice")
+			alice.listen()
+			alice.receive(t, tt.event)
+			if tt.want == nil {
+				alice.expectDropped(t)
+				return
+			}
+			if n := alice.nextNotification(t); n != tt.want {
+				t.Fatalf("got %#v, want %#v", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	alice := newTestClient(t, "alice")
+	before := time.Now()
+	alice.Typing()
+	alice.conn.mu.Lock()
+	deadline := alice.conn.deadline
+	alice.conn.mu.Unlock()
+	if deadline.Before(before.Add(writeWait)) || deadline.After(time.Now().Add(writeWait)) {
+		t.Fatalf("the write deadline is %v after the write, want %v", deadline.Sub(before), writeWait)
+	}
+
+	// a stalled connection is dropped, the supervisor dials again
+	alice.conn.failWrites(errors.New("i/o timeout"))
+	alice.StopTyping()
+	if !alice.conn.isClosed() {
+		t.Fatal("the connection was kept after a failed write")
+	}
+}